@@ -0,0 +1,95 @@
+package crypto
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+)
+
+var (
+	ErrDecrypt = errors.New("crypto: message authentication failed")
+)
+
+// seal
+// 输出格式: 随机 nonce + 密文 + tag
+func seal(aead cipher.AEAD, src, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(src)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, src, additionalData), nil
+}
+
+// open
+// 长度不足和校验失败都返回 ErrDecrypt, 不区分失败原因
+func open(aead cipher.AEAD, src, additionalData []byte) ([]byte, error) {
+	if len(src) < aead.NonceSize()+aead.Overhead() {
+		return nil, ErrDecrypt
+	}
+	nonce, ciphertext := src[:aead.NonceSize()], src[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, additionalData)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
+
+// AEADCipher
+// 每次加密使用随机 nonce, Algorithm 决定底层的 AEAD.
+// AES256GCM 的 nonce 只有 12 字节, 同一个 key 加密的消息数不应超过 2^32; XChaCha20Poly1305 没有这个限制
+type AEADCipher struct {
+	cipher.AEAD
+	Encode
+	Algorithm Algorithm
+}
+
+func (c *AEADCipher) Encrypt(src []byte) (string, error) {
+	return c.EncryptWithAD(src, nil)
+}
+
+func (c *AEADCipher) Decrypt(raw string) ([]byte, error) {
+	return c.DecryptWithAD(raw, nil)
+}
+
+// EncryptWithAD
+// additionalData 参与认证但不加密, 解密时必须提供相同的值
+func (c *AEADCipher) EncryptWithAD(src, additionalData []byte) (string, error) {
+	ciphertext, err := seal(c.AEAD, src, additionalData)
+	if err != nil {
+		return "", err
+	}
+	return c.EncodeToString(ciphertext), nil
+}
+
+func (c *AEADCipher) DecryptWithAD(raw string, additionalData []byte) ([]byte, error) {
+	src, err := c.DecodeString(raw)
+	if err != nil {
+		return nil, err
+	}
+	return open(c.AEAD, src, additionalData)
+}
+
+// NewAEADCipher
+// key 必须是 32 字节, encode 为空时使用 Hex
+func NewAEADCipher(algorithm Algorithm, key []byte, encode Encode) (*AEADCipher, error) {
+	aead, err := algorithm.AEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if encode == nil {
+		encode = Hex
+	}
+	return &AEADCipher{
+		AEAD:      aead,
+		Encode:    encode,
+		Algorithm: algorithm,
+	}, nil
+}
+
+func NewGCMCipher(key []byte, encode Encode) (*AEADCipher, error) {
+	return NewAEADCipher(AES256GCM, key, encode)
+}
+
+func NewXChaCha20Cipher(key []byte, encode Encode) (*AEADCipher, error) {
+	return NewAEADCipher(XChaCha20Poly1305, key, encode)
+}
@@ -0,0 +1,79 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestAEADCipher(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	gcm, err := NewGCMCipher(key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	xchacha, err := NewXChaCha20Cipher(key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, c := range map[string]interface {
+		EncryptWithAD(src, additionalData []byte) (string, error)
+		DecryptWithAD(raw string, additionalData []byte) ([]byte, error)
+		Encode
+	}{"gcm": gcm, "xchacha20": xchacha} {
+		raw, err := c.EncryptWithAD([]byte("hello"), []byte("user:1"))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		plaintext, err := c.DecryptWithAD(raw, []byte("user:1"))
+		if err != nil || string(plaintext) != "hello" {
+			t.Fatalf("%s: decrypt %q %v", name, plaintext, err)
+		}
+		if _, err = c.DecryptWithAD(raw, []byte("user:2")); !errors.Is(err, ErrDecrypt) {
+			t.Fatalf("%s: expected associated data mismatch to fail, got %v", name, err)
+		}
+		data, _ := c.DecodeString(raw)
+		data[len(data)-1] ^= 1
+		if _, err = c.DecryptWithAD(c.EncodeToString(data), []byte("user:1")); !errors.Is(err, ErrDecrypt) {
+			t.Fatalf("%s: expected tampered ciphertext to fail, got %v", name, err)
+		}
+		if _, err = c.DecryptWithAD(c.EncodeToString(data[:4]), nil); !errors.Is(err, ErrDecrypt) {
+			t.Fatalf("%s: expected short ciphertext to fail, got %v", name, err)
+		}
+	}
+}
+
+func TestCBCPadding(t *testing.T) {
+	c, err := NewCBCCipher(bytes.Repeat([]byte{1}, 16), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, size := range []int{0, 1, 15, 16, 17} {
+		raw, err := c.Encrypt(bytes.Repeat([]byte{'a'}, size))
+		if err != nil {
+			t.Fatal(err)
+		}
+		plaintext, err := c.Decrypt(raw)
+		if err != nil || len(plaintext) != size {
+			t.Fatalf("size %d: decrypt %d bytes %v", size, len(plaintext), err)
+		}
+	}
+	for _, src := range [][]byte{
+		nil,
+		bytes.Repeat([]byte{0}, 16),
+		bytes.Repeat([]byte{17}, 16),
+		append(bytes.Repeat([]byte{1}, 14), 3, 2),
+		bytes.Repeat([]byte{1}, 15),
+		{5},
+	} {
+		if _, err = c.CheckedUPKCS7Padding(src); !errors.Is(err, ErrPadding) {
+			t.Fatalf("expected %v to be rejected, got %v", src, err)
+		}
+		if got := c.UPKCS7Padding(src); got != nil {
+			t.Fatalf("expected %v to be rejected, got %v", src, got)
+		}
+	}
+	if _, err = c.Decrypt(Hex.EncodeToString(make([]byte, 20))); !errors.Is(err, ErrPadding) {
+		t.Fatalf("expected unaligned ciphertext to fail, got %v", err)
+	}
+}
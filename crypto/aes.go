@@ -5,13 +5,16 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 )
 
 var (
-	Hex = hexEncode{}
+	Hex        = hexEncode{}
+	ErrPadding = errors.New("crypto: invalid padding")
 )
 
 type Encode interface {
@@ -76,13 +79,35 @@ func (c *CBCCipher) PKCS7Padding(cipherText []byte, blockSize int) []byte {
 	return append(cipherText, padText...)
 }
 
+// UPKCS7Padding
+// Deprecated: 填充不合法时返回 nil, 无法区分错误, 使用 CheckedUPKCS7Padding
 func (c *CBCCipher) UPKCS7Padding(src []byte) []byte {
+	src, err := c.CheckedUPKCS7Padding(src)
+	if err != nil {
+		return nil
+	}
+	return src
+}
+
+// CheckedUPKCS7Padding
+// 以常量时间检查填充, 长度不是块大小的整数倍或填充不合法时返回 ErrPadding
+func (c *CBCCipher) CheckedUPKCS7Padding(src []byte) ([]byte, error) {
 	n := len(src)
-	if n == 0 {
-		return src
+	if n == 0 || n%aes.BlockSize != 0 {
+		return nil, ErrPadding
 	}
 	paddingNum := int(src[n-1])
-	return src[:n-paddingNum]
+	good := subtle.ConstantTimeLessOrEq(1, paddingNum) & subtle.ConstantTimeLessOrEq(paddingNum, aes.BlockSize)
+	for i := 1; i <= aes.BlockSize; i++ {
+		// 只检查最后 paddingNum 个字节, 其余位置的比较结果被忽略
+		inPadding := subtle.ConstantTimeLessOrEq(i, paddingNum)
+		match := subtle.ConstantTimeByteEq(src[n-i], byte(paddingNum))
+		good &= subtle.ConstantTimeSelect(inPadding, match, 1)
+	}
+	if good != 1 {
+		return nil, ErrPadding
+	}
+	return src[:n-paddingNum], nil
 }
 
 func (c *CBCCipher) Encrypt(src []byte) (string, error) {
@@ -103,14 +128,14 @@ func (c *CBCCipher) Decrypt(raw string) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	if len(src) < aes.BlockSize {
-		return nil, fmt.Errorf("cipher text is too short")
+	if len(src) < 2*aes.BlockSize || len(src)%aes.BlockSize != 0 {
+		return nil, ErrPadding
 	}
 	iv := src[:aes.BlockSize]
 	src = src[aes.BlockSize:]
 	stream := cipher.NewCBCDecrypter(c.Block, iv)
 	stream.CryptBlocks(src, src)
-	return c.UPKCS7Padding(src), nil
+	return c.CheckedUPKCS7Padding(src)
 }
 
 func NewCBCCipher(key []byte, encode Encode) (*CBCCipher, error) {
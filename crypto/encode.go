@@ -0,0 +1,143 @@
+package crypto
+
+import (
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	Base64       Encode = base64.StdEncoding
+	Base64URL    Encode = base64.URLEncoding
+	RawBase64    Encode = base64.RawStdEncoding
+	RawBase64URL Encode = base64.RawURLEncoding
+	Base32       Encode = base32.StdEncoding
+	Base58              = base58Encode{}
+	// Auto 编码为带 "~" 前缀的 RawBase64URL, 解码时兼容 hex 和 base64 的各种变体, 可以用来从 Hex 迁移到更短的格式
+	Auto = NewAutoEncode(RawBase64URL, Hex, Base64, Base64URL, RawBase64, RawBase64URL)
+
+	ErrEncoding = errors.New("crypto: unrecognized encoding")
+)
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+var base58Index = func() (index [256]int8) {
+	for i := range index {
+		index[i] = -1
+	}
+	for i := 0; i < len(base58Alphabet); i++ {
+		index[base58Alphabet[i]] = int8(i)
+	}
+	return index
+}()
+
+// base58Encode
+// 比特币字母表, 前导 0 字节编码为 '1'
+type base58Encode struct {
+}
+
+func (b base58Encode) EncodeToString(src []byte) string {
+	zeros := 0
+	for zeros < len(src) && src[zeros] == 0 {
+		zeros++
+	}
+	// log(256) / log(58) ≈ 1.37
+	digits := make([]byte, 0, (len(src)-zeros)*138/100+1)
+	for _, c := range src[zeros:] {
+		carry := int(c)
+		for i := range digits {
+			carry += int(digits[i]) << 8
+			digits[i] = byte(carry % 58)
+			carry /= 58
+		}
+		for carry > 0 {
+			digits = append(digits, byte(carry%58))
+			carry /= 58
+		}
+	}
+	dst := make([]byte, zeros+len(digits))
+	for i := 0; i < zeros; i++ {
+		dst[i] = '1'
+	}
+	for i, d := range digits {
+		dst[len(dst)-1-i] = base58Alphabet[d]
+	}
+	return string(dst)
+}
+
+func (b base58Encode) DecodeString(s string) ([]byte, error) {
+	zeros := 0
+	for zeros < len(s) && s[zeros] == '1' {
+		zeros++
+	}
+	// log(58) / log(256) ≈ 0.733
+	bytes := make([]byte, 0, (len(s)-zeros)*733/1000+1)
+	for i := zeros; i < len(s); i++ {
+		v := base58Index[s[i]]
+		if v < 0 {
+			return nil, fmt.Errorf("illegal base58 data at input byte %d", i)
+		}
+		carry := int(v)
+		for j := range bytes {
+			carry += int(bytes[j]) * 58
+			bytes[j] = byte(carry)
+			carry >>= 8
+		}
+		for carry > 0 {
+			bytes = append(bytes, byte(carry))
+			carry >>= 8
+		}
+	}
+	dst := make([]byte, zeros+len(bytes))
+	for i, c := range bytes {
+		dst[len(dst)-1-i] = c
+	}
+	return dst, nil
+}
+
+// autoEncodePrefix
+// 不属于 hex 和 base64 任何变体的字符集, 带前缀的字符串一定是 autoEncode 自己的输出
+const autoEncodePrefix = "~"
+
+// autoEncode
+// 编码结果带 autoEncodePrefix, 解码时带前缀的按 Encode 解码, 否则按顺序尝试每种编码, 返回第一个解码成功的结果
+type autoEncode struct {
+	Encode
+	decoders []Encode
+}
+
+func (a autoEncode) EncodeToString(src []byte) string {
+	return autoEncodePrefix + a.Encode.EncodeToString(src)
+}
+
+func (a autoEncode) DecodeString(s string) ([]byte, error) {
+	if rest, ok := strings.CutPrefix(s, autoEncodePrefix); ok {
+		data, err := a.Encode.DecodeString(rest)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrEncoding, err)
+		}
+		return data, nil
+	}
+	for _, decoder := range a.decoders {
+		if data, err := decoder.DecodeString(s); err == nil {
+			return data, nil
+		}
+	}
+	return nil, ErrEncoding
+}
+
+// NewAutoEncode
+// 使用 encode 编码并加上前缀, 保证自己的输出总能原样解码. 不带前缀的旧数据按 decoders 的顺序尝试,
+// 同一个字符串可能同时是多种编码的合法输入(例如只含 0-9a-f 的 base64), 这时以排在前面的为准,
+// 所以字符集越小的编码越应该放在前面
+func NewAutoEncode(encode Encode, decoders ...Encode) Encode {
+	if len(decoders) == 0 {
+		decoders = []Encode{encode}
+	}
+	return autoEncode{
+		Encode:   encode,
+		decoders: decoders,
+	}
+}
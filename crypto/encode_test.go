@@ -0,0 +1,73 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+func TestBase58(t *testing.T) {
+	for src, want := range map[string]string{
+		"":             "",
+		"Hello World!": "2NEpo7TZRRrLZSi2U",
+		"\x00\x00\x01": "112",
+		"\x00":         "1",
+	} {
+		if got := Base58.EncodeToString([]byte(src)); got != want {
+			t.Fatalf("encode %q: got %q want %q", src, got, want)
+		}
+		if got, err := Base58.DecodeString(want); err != nil || string(got) != src {
+			t.Fatalf("decode %q: got %q %v", want, got, err)
+		}
+	}
+	if _, err := Base58.DecodeString("0OIl"); err == nil {
+		t.Fatal("expected invalid base58 to fail")
+	}
+}
+
+func TestEncode(t *testing.T) {
+	src := make([]byte, 33)
+	rand.Read(src)
+	encodes := []Encode{Hex, Base64, Base64URL, RawBase64, RawBase64URL, Base32, Base58}
+	for _, encode := range encodes {
+		if got, err := encode.DecodeString(encode.EncodeToString(src)); err != nil || !bytes.Equal(got, src) {
+			t.Fatalf("%T: round trip %v", encode, err)
+		}
+	}
+	for _, encode := range encodes[:5] {
+		if got, err := Auto.DecodeString(encode.EncodeToString(src)); err != nil || !bytes.Equal(got, src) {
+			t.Fatalf("auto decode %q: %v", encode.EncodeToString(src), err)
+		}
+	}
+	// "abcd" 同时是合法的 hex, Auto 的输出不能被当成 hex 解码
+	vector := []byte{0x69, 0xb7, 0x1d}
+	if got := Auto.EncodeToString(vector); got != "~abcd" {
+		t.Fatalf("auto encode %x: got %q", vector, got)
+	}
+	if got, err := Auto.DecodeString(Auto.EncodeToString(vector)); err != nil || !bytes.Equal(got, vector) {
+		t.Fatalf("auto round trip %x: got %x %v", vector, got, err)
+	}
+	if got, err := Auto.DecodeString("abcd"); err != nil || !bytes.Equal(got, []byte{0xab, 0xcd}) {
+		t.Fatalf("auto decode legacy hex: got %x %v", got, err)
+	}
+	if _, err := Auto.DecodeString("~not*encoded"); !errors.Is(err, ErrEncoding) {
+		t.Fatalf("expected invalid tagged input to fail, got %v", err)
+	}
+	if _, err := Auto.DecodeString("not*encoded"); !errors.Is(err, ErrEncoding) {
+		t.Fatalf("expected unrecognized encoding, got %v", err)
+	}
+
+	c, err := NewGCMCipher(bytes.Repeat([]byte{1}, 32), Auto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := c.Encrypt([]byte("hello"))
+	if len(raw) != 1+RawBase64URL.(interface{ EncodedLen(int) int }).EncodedLen(12+5+16) {
+		t.Fatalf("expected raw url base64 output, got %q", raw)
+	}
+	old, _ := (&AEADCipher{AEAD: c.AEAD, Encode: Hex, Algorithm: c.Algorithm}).Encrypt([]byte("hello"))
+	if plaintext, err := c.Decrypt(old); err != nil || string(plaintext) != "hello" {
+		t.Fatalf("decrypt hex ciphertext %q %v", plaintext, err)
+	}
+}
@@ -0,0 +1,301 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/chacha20poly1305"
+	"sync"
+)
+
+const (
+	EnvelopeVersion         = 1
+	EnvelopeVersionPassword = 2
+)
+
+var (
+	ErrInvalidEnvelope      = errors.New("crypto: invalid envelope")
+	ErrUnsupportedVersion   = errors.New("crypto: unsupported envelope version")
+	ErrUnsupportedAlgorithm = errors.New("crypto: unsupported algorithm")
+	ErrUnknownKey           = errors.New("crypto: unknown key id")
+)
+
+type Algorithm byte
+
+const (
+	AES256GCM Algorithm = iota + 1
+	XChaCha20Poly1305
+)
+
+func (a Algorithm) String() string {
+	switch a {
+	case AES256GCM:
+		return "AES-256-GCM"
+	case XChaCha20Poly1305:
+		return "XChaCha20-Poly1305"
+	default:
+		return fmt.Sprintf("Algorithm(%d)", byte(a))
+	}
+}
+
+func (a Algorithm) NonceSize() int {
+	switch a {
+	case AES256GCM:
+		return 12
+	case XChaCha20Poly1305:
+		return chacha20poly1305.NonceSizeX
+	default:
+		return 0
+	}
+}
+
+func (a Algorithm) AEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("crypto: %s requires a 32-byte key, got %d", a, len(key))
+	}
+	switch a {
+	case AES256GCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	case XChaCha20Poly1305:
+		return chacha20poly1305.NewX(key)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, a)
+	}
+}
+
+// Envelope
+// 格式: 版本(1) + 算法(1) + key ID 长度(1) + key ID + nonce + 密文和 tag,
+// 版本 2 由密码派生 key, 在 key ID 之后增加 KDF 参数(13) + salt 长度(1) + salt.
+// nonce 之前的头部作为附加数据参与认证
+type Envelope struct {
+	Version    byte
+	Algorithm  Algorithm
+	KeyID      string
+	KDF        *KDFParams
+	Salt       []byte
+	Nonce      []byte
+	Ciphertext []byte
+}
+
+func (e *Envelope) header() []byte {
+	header := make([]byte, 0, 3+len(e.KeyID)+kdfParamsSize+1+len(e.Salt))
+	header = append(header, e.Version, byte(e.Algorithm), byte(len(e.KeyID)))
+	header = append(header, e.KeyID...)
+	if e.Version == EnvelopeVersionPassword {
+		header = e.KDF.marshal(header)
+		header = append(header, byte(len(e.Salt)))
+		header = append(header, e.Salt...)
+	}
+	return header
+}
+
+func (e *Envelope) Marshal() []byte {
+	data := e.header()
+	data = append(data, e.Nonce...)
+	return append(data, e.Ciphertext...)
+}
+
+func ParseEnvelope(data []byte) (*Envelope, error) {
+	if len(data) < 3 {
+		return nil, ErrInvalidEnvelope
+	}
+	if data[0] != EnvelopeVersion && data[0] != EnvelopeVersionPassword {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, data[0])
+	}
+	e := &Envelope{Version: data[0], Algorithm: Algorithm(data[1])}
+	nonceSize := e.Algorithm.NonceSize()
+	if nonceSize == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, e.Algorithm)
+	}
+	idLen := int(data[2])
+	data = data[3:]
+	if len(data) < idLen {
+		return nil, ErrInvalidEnvelope
+	}
+	e.KeyID, data = string(data[:idLen]), data[idLen:]
+	if e.Version == EnvelopeVersionPassword {
+		var err error
+		if e.KDF, err = unmarshalKDFParams(data); err != nil {
+			return nil, err
+		}
+		data = data[kdfParamsSize:]
+		if len(data) < 1 || len(data) < 1+int(data[0]) {
+			return nil, ErrInvalidEnvelope
+		}
+		e.Salt, data = data[1:1+int(data[0])], data[1+int(data[0]):]
+	}
+	if len(data) < nonceSize {
+		return nil, ErrInvalidEnvelope
+	}
+	e.Nonce, e.Ciphertext = data[:nonceSize], data[nonceSize:]
+	return e, nil
+}
+
+type keyringKey struct {
+	algorithm Algorithm
+	aead      cipher.AEAD
+}
+
+// Keyring
+// 使用当前 key 加密, 使用 key ID 对应的任意 key 解密; 轮换时 Add 新 key 并 SetActive,
+// 旧 key 保留到所有数据 ReEncrypt 完成后再 Remove
+type Keyring struct {
+	Encode
+	mutex  sync.RWMutex
+	active string
+	keys   map[string]keyringKey
+}
+
+// Add
+// 第一个添加的 key 自动成为当前 key
+func (k *Keyring) Add(id string, algorithm Algorithm, key []byte) error {
+	if id == "" || len(id) > 255 {
+		return fmt.Errorf("crypto: key id must be 1-255 bytes")
+	}
+	aead, err := algorithm.AEAD(key)
+	if err != nil {
+		return err
+	}
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.keys[id] = keyringKey{algorithm: algorithm, aead: aead}
+	if k.active == "" {
+		k.active = id
+	}
+	return nil
+}
+
+func (k *Keyring) SetActive(id string) error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if _, ok := k.keys[id]; !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownKey, id)
+	}
+	k.active = id
+	return nil
+}
+
+func (k *Keyring) Active() string {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	return k.active
+}
+
+// Remove
+// 不能删除当前 key
+func (k *Keyring) Remove(id string) error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if id == k.active {
+		return fmt.Errorf("crypto: cannot remove active key %s", id)
+	}
+	delete(k.keys, id)
+	return nil
+}
+
+func (k *Keyring) key(id string) (keyringKey, error) {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	key, ok := k.keys[id]
+	if !ok {
+		return key, fmt.Errorf("%w: %q", ErrUnknownKey, id)
+	}
+	return key, nil
+}
+
+func (k *Keyring) Encrypt(src []byte) (string, error) {
+	return k.EncryptWithAD(src, nil)
+}
+
+func (k *Keyring) Decrypt(raw string) ([]byte, error) {
+	return k.DecryptWithAD(raw, nil)
+}
+
+func (k *Keyring) EncryptWithAD(src, additionalData []byte) (string, error) {
+	data, err := k.seal(k.Active(), src, additionalData)
+	if err != nil {
+		return "", err
+	}
+	return k.EncodeToString(data), nil
+}
+
+func (k *Keyring) DecryptWithAD(raw string, additionalData []byte) ([]byte, error) {
+	data, err := k.DecodeString(raw)
+	if err != nil {
+		return nil, err
+	}
+	e, err := ParseEnvelope(data)
+	if err != nil {
+		return nil, err
+	}
+	return k.open(e, additionalData)
+}
+
+func (k *Keyring) seal(id string, src, additionalData []byte) ([]byte, error) {
+	key, err := k.key(id)
+	if err != nil {
+		return nil, err
+	}
+	e := &Envelope{Version: EnvelopeVersion, Algorithm: key.algorithm, KeyID: id}
+	sealed, err := seal(key.aead, src, append(e.header(), additionalData...))
+	if err != nil {
+		return nil, err
+	}
+	e.Nonce, e.Ciphertext = sealed[:key.aead.NonceSize()], sealed[key.aead.NonceSize():]
+	return e.Marshal(), nil
+}
+
+func (k *Keyring) open(e *Envelope, additionalData []byte) ([]byte, error) {
+	key, err := k.key(e.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	if key.algorithm != e.Algorithm {
+		return nil, ErrDecrypt
+	}
+	plaintext, err := key.aead.Open(nil, e.Nonce, e.Ciphertext, append(e.header(), additionalData...))
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
+
+// ReEncrypt
+// 用当前 key 重新加密, 已经是当前 key 时原样返回且 changed 为 false
+func (k *Keyring) ReEncrypt(raw string, additionalData []byte) (result string, changed bool, err error) {
+	data, err := k.DecodeString(raw)
+	if err != nil {
+		return "", false, err
+	}
+	e, err := ParseEnvelope(data)
+	if err != nil {
+		return "", false, err
+	}
+	active := k.Active()
+	if e.KeyID == active {
+		return raw, false, nil
+	}
+	plaintext, err := k.open(e, additionalData)
+	if err != nil {
+		return "", false, err
+	}
+	if data, err = k.seal(active, plaintext, additionalData); err != nil {
+		return "", false, err
+	}
+	return k.EncodeToString(data), true, nil
+}
+
+func NewKeyring(encode Encode) *Keyring {
+	if encode == nil {
+		encode = Hex
+	}
+	return &Keyring{
+		Encode: encode,
+		keys:   make(map[string]keyringKey),
+	}
+}
@@ -0,0 +1,69 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestKeyring(t *testing.T) {
+	keyring := NewKeyring(nil)
+	if err := keyring.Add("2024", AES256GCM, bytes.Repeat([]byte{1}, 32)); err != nil {
+		t.Fatal(err)
+	}
+	if err := keyring.Add("bad", AES256GCM, []byte("secret")); err == nil {
+		t.Fatal("expected short key to fail")
+	}
+	old, err := keyring.EncryptWithAD([]byte("hello"), []byte("row:1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := keyring.DecodeString(old)
+	e, err := ParseEnvelope(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Version != EnvelopeVersion || e.KeyID != "2024" || e.Algorithm != AES256GCM || len(e.Nonce) != 12 {
+		t.Fatalf("unexpected envelope %+v", e)
+	}
+
+	// 轮换到新 key 后旧密文仍可解密
+	if err = keyring.Add("2025", XChaCha20Poly1305, bytes.Repeat([]byte{2}, 32)); err != nil {
+		t.Fatal(err)
+	}
+	if err = keyring.SetActive("2025"); err != nil {
+		t.Fatal(err)
+	}
+	if plaintext, err := keyring.DecryptWithAD(old, []byte("row:1")); err != nil || string(plaintext) != "hello" {
+		t.Fatalf("decrypt %q %v", plaintext, err)
+	}
+	updated, changed, err := keyring.ReEncrypt(old, []byte("row:1"))
+	if err != nil || !changed {
+		t.Fatalf("re-encrypt changed=%v %v", changed, err)
+	}
+	if _, changed, _ = keyring.ReEncrypt(updated, []byte("row:1")); changed {
+		t.Fatal("expected active key ciphertext to be unchanged")
+	}
+	if err = keyring.Remove("2025"); err == nil {
+		t.Fatal("expected removing active key to fail")
+	}
+	if err = keyring.Remove("2024"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = keyring.Decrypt(old); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("expected unknown key, got %v", err)
+	}
+	if plaintext, err := keyring.DecryptWithAD(updated, []byte("row:1")); err != nil || string(plaintext) != "hello" {
+		t.Fatalf("decrypt %q %v", plaintext, err)
+	}
+
+	// 篡改头部中的算法或 key ID 会导致认证失败
+	data, _ = keyring.DecodeString(updated)
+	data[1] = byte(AES256GCM)
+	if _, err = keyring.Decrypt(keyring.EncodeToString(data)); err == nil {
+		t.Fatal("expected tampered algorithm to fail")
+	}
+	if _, err = ParseEnvelope([]byte{3, 1, 0}); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("expected unsupported version, got %v", err)
+	}
+}
@@ -0,0 +1,254 @@
+package crypto
+
+import (
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	SaltSize = 16
+)
+
+type KDF byte
+
+const (
+	Argon2id KDF = iota + 1
+	Scrypt
+	PBKDF2
+)
+
+func (k KDF) String() string {
+	switch k {
+	case Argon2id:
+		return "argon2id"
+	case Scrypt:
+		return "scrypt"
+	case PBKDF2:
+		return "pbkdf2-sha256"
+	default:
+		return fmt.Sprintf("KDF(%d)", byte(k))
+	}
+}
+
+var (
+	ErrKDFParams = errors.New("crypto: invalid kdf params")
+)
+
+// KDFParams
+// Argon2id 使用 Time、Memory(KiB)、Threads, scrypt 使用 N、R、P, PBKDF2 使用 Iterations
+type KDFParams struct {
+	KDF        KDF    `json:"kdf" toml:"kdf" yaml:"kdf"`
+	Time       uint32 `json:"time" toml:"time" yaml:"time"`
+	Memory     uint32 `json:"memory" toml:"memory" yaml:"memory"`
+	Threads    uint8  `json:"threads" toml:"threads" yaml:"threads"`
+	N          uint32 `json:"n" toml:"n" yaml:"n"`
+	R          uint32 `json:"r" toml:"r" yaml:"r"`
+	P          uint32 `json:"p" toml:"p" yaml:"p"`
+	Iterations uint32 `json:"iterations" toml:"iterations" yaml:"iterations"`
+}
+
+// DefaultKDFParams
+// Argon2id 按 RFC 9106 的第二推荐配置, scrypt 和 PBKDF2 按 OWASP 的推荐值
+func DefaultKDFParams(kdf KDF) KDFParams {
+	switch kdf {
+	case Scrypt:
+		return KDFParams{KDF: Scrypt, N: 1 << 15, R: 8, P: 1}
+	case PBKDF2:
+		return KDFParams{KDF: PBKDF2, Iterations: 600_000}
+	default:
+		return KDFParams{KDF: Argon2id, Time: 3, Memory: 64 * 1024, Threads: 4}
+	}
+}
+
+// KDF 参数的上限, 解密时参数来自密文, 内存上限同为 256 MiB
+const (
+	maxArgon2Time   = 16
+	maxArgon2Memory = 256 * 1024
+	maxScryptN      = 1 << 20
+	maxScryptR      = 32
+	maxScryptP      = 16
+	maxScryptMemory = 256 << 20
+)
+
+// validate
+// 参数来自密文时同样会检查上限, 防止篡改的参数消耗过多 CPU 和内存.
+// scrypt 的内存占用约为 128*N*R 字节
+func (p *KDFParams) validate() error {
+	switch p.KDF {
+	case Argon2id:
+		if p.Time == 0 || p.Time > maxArgon2Time || p.Memory < 8 || p.Memory > maxArgon2Memory || p.Threads == 0 {
+			return fmt.Errorf("%w: argon2id time=%d memory=%d threads=%d", ErrKDFParams, p.Time, p.Memory, p.Threads)
+		}
+	case Scrypt:
+		if p.N < 2 || p.N&(p.N-1) != 0 || p.N > maxScryptN || p.R == 0 || p.R > maxScryptR || p.P == 0 || p.P > maxScryptP ||
+			128*uint64(p.N)*uint64(p.R) > maxScryptMemory {
+			return fmt.Errorf("%w: scrypt n=%d r=%d p=%d", ErrKDFParams, p.N, p.R, p.P)
+		}
+	case PBKDF2:
+		if p.Iterations == 0 || p.Iterations > 10_000_000 {
+			return fmt.Errorf("%w: pbkdf2 iterations=%d", ErrKDFParams, p.Iterations)
+		}
+	default:
+		return fmt.Errorf("%w: unknown kdf %s", ErrKDFParams, p.KDF)
+	}
+	return nil
+}
+
+// DeriveKey
+// 从密码派生 size 字节的 key, 可以直接传给 NewCFBCipher、NewGCMCipher 等
+func (p *KDFParams) DeriveKey(password, salt []byte, size int) ([]byte, error) {
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	switch p.KDF {
+	case Argon2id:
+		return argon2.IDKey(password, salt, p.Time, p.Memory, p.Threads, uint32(size)), nil
+	case Scrypt:
+		return scrypt.Key(password, salt, int(p.N), int(p.R), int(p.P), size)
+	default:
+		return pbkdf2.Key(sha256.New, string(password), salt, int(p.Iterations), size)
+	}
+}
+
+// marshal
+// 格式: kdf(1) + 3 个大端 uint32 参数
+func (p *KDFParams) marshal(dst []byte) []byte {
+	dst = append(dst, byte(p.KDF))
+	switch p.KDF {
+	case Argon2id:
+		dst = binary.BigEndian.AppendUint32(dst, p.Time)
+		dst = binary.BigEndian.AppendUint32(dst, p.Memory)
+		return binary.BigEndian.AppendUint32(dst, uint32(p.Threads))
+	case Scrypt:
+		dst = binary.BigEndian.AppendUint32(dst, p.N)
+		dst = binary.BigEndian.AppendUint32(dst, p.R)
+		return binary.BigEndian.AppendUint32(dst, p.P)
+	default:
+		dst = binary.BigEndian.AppendUint32(dst, p.Iterations)
+		return append(dst, make([]byte, 8)...)
+	}
+}
+
+const kdfParamsSize = 13
+
+func unmarshalKDFParams(data []byte) (*KDFParams, error) {
+	if len(data) < kdfParamsSize {
+		return nil, ErrInvalidEnvelope
+	}
+	p := &KDFParams{KDF: KDF(data[0])}
+	a := binary.BigEndian.Uint32(data[1:])
+	b := binary.BigEndian.Uint32(data[5:])
+	c := binary.BigEndian.Uint32(data[9:])
+	switch p.KDF {
+	case Argon2id:
+		if c > 255 {
+			return nil, fmt.Errorf("%w: argon2id threads=%d", ErrKDFParams, c)
+		}
+		p.Time, p.Memory, p.Threads = a, b, uint8(c)
+	case Scrypt:
+		p.N, p.R, p.P = a, b, c
+	default:
+		p.Iterations = a
+	}
+	return p, p.validate()
+}
+
+// HKDF
+// HKDF-SHA256, 从一个主 key 按 info 派生互相独立的子 key
+func HKDF(secret, salt []byte, info string, size int) ([]byte, error) {
+	return hkdf.Key(sha256.New, secret, salt, info, size)
+}
+
+// PasswordCipher
+// 每次加密生成随机 salt, salt 和 KDF 参数保存在信封中, 修改参数后旧密文仍然可以解密.
+// 每次加解密都会执行一次 KDF, 不适合高频调用, 高频场景用 DeriveKey 派生 key 后使用 Keyring
+type PasswordCipher struct {
+	Encode
+	password  []byte
+	params    KDFParams
+	algorithm Algorithm
+}
+
+func (c *PasswordCipher) Encrypt(src []byte) (string, error) {
+	return c.EncryptWithAD(src, nil)
+}
+
+func (c *PasswordCipher) Decrypt(raw string) ([]byte, error) {
+	return c.DecryptWithAD(raw, nil)
+}
+
+func (c *PasswordCipher) EncryptWithAD(src, additionalData []byte) (string, error) {
+	e := &Envelope{Version: EnvelopeVersionPassword, Algorithm: c.algorithm, KDF: &c.params, Salt: make([]byte, SaltSize)}
+	if _, err := rand.Read(e.Salt); err != nil {
+		return "", err
+	}
+	aead, err := c.aead(e)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := seal(aead, src, append(e.header(), additionalData...))
+	if err != nil {
+		return "", err
+	}
+	e.Nonce, e.Ciphertext = sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return c.EncodeToString(e.Marshal()), nil
+}
+
+func (c *PasswordCipher) DecryptWithAD(raw string, additionalData []byte) ([]byte, error) {
+	data, err := c.DecodeString(raw)
+	if err != nil {
+		return nil, err
+	}
+	e, err := ParseEnvelope(data)
+	if err != nil {
+		return nil, err
+	}
+	if e.Version != EnvelopeVersionPassword {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, e.Version)
+	}
+	aead, err := c.aead(e)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, e.Nonce, e.Ciphertext, append(e.header(), additionalData...))
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
+
+func (c *PasswordCipher) aead(e *Envelope) (cipher.AEAD, error) {
+	key, err := e.KDF.DeriveKey(c.password, e.Salt, 32)
+	if err != nil {
+		return nil, err
+	}
+	return e.Algorithm.AEAD(key)
+}
+
+// NewPasswordCipher
+// params 为零值时使用 DefaultKDFParams(Argon2id), 加密算法为 XChaCha20-Poly1305
+func NewPasswordCipher(password []byte, params KDFParams, encode Encode) (*PasswordCipher, error) {
+	if params == (KDFParams{}) {
+		params = DefaultKDFParams(Argon2id)
+	}
+	if err := params.validate(); err != nil {
+		return nil, err
+	}
+	if encode == nil {
+		encode = Hex
+	}
+	return &PasswordCipher{
+		Encode:    encode,
+		password:  password,
+		params:    params,
+		algorithm: XChaCha20Poly1305,
+	}, nil
+}
@@ -0,0 +1,120 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+// 测试使用最低的成本参数
+var testKDFParams = []KDFParams{
+	{KDF: Argon2id, Time: 1, Memory: 64, Threads: 1},
+	{KDF: Scrypt, N: 16, R: 1, P: 1},
+	{KDF: PBKDF2, Iterations: 1},
+}
+
+func TestDeriveKey(t *testing.T) {
+	for _, params := range testKDFParams {
+		key, err := params.DeriveKey([]byte("secret"), []byte("salt"), 32)
+		if err != nil || len(key) != 32 {
+			t.Fatalf("%s: %d bytes %v", params.KDF, len(key), err)
+		}
+		again, _ := params.DeriveKey([]byte("secret"), []byte("salt"), 32)
+		other, _ := params.DeriveKey([]byte("secret"), []byte("pepper"), 32)
+		if !bytes.Equal(key, again) || bytes.Equal(key, other) {
+			t.Fatalf("%s: derivation is not deterministic per salt", params.KDF)
+		}
+		if _, err = NewCBCCipher(key, nil); err != nil {
+			t.Fatalf("%s: %v", params.KDF, err)
+		}
+	}
+	if _, err := (&KDFParams{KDF: Scrypt, N: 1000, R: 1, P: 1}).DeriveKey(nil, nil, 32); !errors.Is(err, ErrKDFParams) {
+		t.Fatalf("expected invalid scrypt n to fail, got %v", err)
+	}
+
+	// RFC 5869 A.1
+	ikm, _ := hex.DecodeString("0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b")
+	salt, _ := hex.DecodeString("000102030405060708090a0b0c")
+	info, _ := hex.DecodeString("f0f1f2f3f4f5f6f7f8f9")
+	okm, err := HKDF(ikm, salt, string(info), 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(okm) != "3cb25f25faacd57a90434f64d0362f2a2d2d0a90cf1a5a4c5db02d56ecc4c5bf34007208d5b887185865" {
+		t.Fatalf("unexpected okm %x", okm)
+	}
+}
+
+func TestPasswordCipher(t *testing.T) {
+	for _, params := range testKDFParams {
+		c, err := NewPasswordCipher([]byte("secret"), params, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, err := c.EncryptWithAD([]byte("hello"), []byte("ad"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := c.DecodeString(raw)
+		e, err := ParseEnvelope(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.Version != EnvelopeVersionPassword || *e.KDF != params || len(e.Salt) != SaltSize {
+			t.Fatalf("unexpected envelope %+v", e)
+		}
+
+		// 解密使用信封中的参数, 不受当前配置影响
+		other, _ := NewPasswordCipher([]byte("secret"), testKDFParams[2], nil)
+		if plaintext, err := other.DecryptWithAD(raw, []byte("ad")); err != nil || string(plaintext) != "hello" {
+			t.Fatalf("%s: decrypt %q %v", params.KDF, plaintext, err)
+		}
+		wrong, _ := NewPasswordCipher([]byte("wrong"), params, nil)
+		if _, err = wrong.DecryptWithAD(raw, []byte("ad")); !errors.Is(err, ErrDecrypt) {
+			t.Fatalf("%s: expected wrong password to fail, got %v", params.KDF, err)
+		}
+		// 篡改 salt 导致认证失败
+		data[len(e.KeyID)+3+kdfParamsSize+1] ^= 1
+		if _, err = c.DecryptWithAD(c.EncodeToString(data), []byte("ad")); !errors.Is(err, ErrDecrypt) {
+			t.Fatalf("%s: expected tampered salt to fail, got %v", params.KDF, err)
+		}
+	}
+	if _, err := NewPasswordCipher([]byte("secret"), KDFParams{KDF: Argon2id, Time: 1, Memory: 1 << 30, Threads: 1}, nil); !errors.Is(err, ErrKDFParams) {
+		t.Fatalf("expected oversized memory to fail, got %v", err)
+	}
+}
+
+func TestKDFParamsLimits(t *testing.T) {
+	for _, params := range []KDFParams{
+		{KDF: Argon2id, Time: 17, Memory: 64, Threads: 1},
+		{KDF: Argon2id, Time: 1, Memory: 256*1024 + 1, Threads: 1},
+		{KDF: Scrypt, N: 1 << 21, R: 1, P: 1},
+		{KDF: Scrypt, N: 16, R: 33, P: 1},
+		{KDF: Scrypt, N: 16, R: 1, P: 17},
+		{KDF: Scrypt, N: 1 << 20, R: 8, P: 1},
+	} {
+		if err := params.validate(); !errors.Is(err, ErrKDFParams) {
+			t.Fatalf("expected %+v to be rejected, got %v", params, err)
+		}
+	}
+	if err := (&KDFParams{KDF: Scrypt, N: 1 << 20, R: 2, P: 16}).validate(); err != nil {
+		t.Fatalf("expected 256 MiB scrypt to pass, got %v", err)
+	}
+
+	// 篡改信封中的参数, 解密必须在派生 key 之前失败
+	c, err := NewPasswordCipher([]byte("secret"), testKDFParams[1], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := c.Encrypt([]byte("hello"))
+	data, _ := c.DecodeString(raw)
+	e, err := ParseEnvelope(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.KDF = &KDFParams{KDF: Scrypt, N: 1 << 22, R: 1 << 20, P: 1 << 10}
+	if _, err = c.Decrypt(c.EncodeToString(e.Marshal())); !errors.Is(err, ErrKDFParams) {
+		t.Fatalf("expected crafted header to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,294 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	p2pcrypto "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	HS256 = "HS256"
+	EdDSA = "EdDSA"
+)
+
+var (
+	ErrSignature     = errors.New("crypto: invalid signature")
+	ErrInvalidToken  = errors.New("crypto: invalid token")
+	ErrTokenExpired  = errors.New("crypto: token expired")
+	ErrTokenReplayed = errors.New("crypto: token replayed")
+	ErrAlgorithm     = errors.New("crypto: unexpected signing algorithm")
+)
+
+// Signer
+// Algorithm 返回 JWS 的 alg
+type Signer interface {
+	Algorithm() string
+	Sign(payload []byte) []byte
+}
+
+type Verifier interface {
+	Algorithm() string
+	Verify(payload, signature []byte) error
+}
+
+// HMACSigner
+// HMAC-SHA256, 同时是 Signer 和 Verifier
+type HMACSigner struct {
+	key   []byte
+	ttl   time.Duration
+	mutex sync.Mutex
+	seen  map[string]time.Time
+	swept time.Time
+}
+
+func (s *HMACSigner) Algorithm() string {
+	return HS256
+}
+
+func (s *HMACSigner) Sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// Verify
+// 常量时间比较
+func (s *HMACSigner) Verify(payload, signature []byte) error {
+	if !hmac.Equal(s.Sign(payload), signature) {
+		return ErrSignature
+	}
+	return nil
+}
+
+// Token
+// 格式: base64url(payload).unix 秒.base64url(随机 nonce).base64url(mac), 适合 webhook 和服务间调用
+func (s *HMACSigner) Token(payload []byte) (string, error) {
+	return s.token(payload, time.Now())
+}
+
+func (s *HMACSigner) token(payload []byte, now time.Time) (string, error) {
+	nonce := make([]byte, 12)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	signed := strings.Join([]string{
+		base64.RawURLEncoding.EncodeToString(payload),
+		strconv.FormatInt(now.Unix(), 10),
+		base64.RawURLEncoding.EncodeToString(nonce),
+	}, ".")
+	return signed + "." + base64.RawURLEncoding.EncodeToString(s.Sign([]byte(signed))), nil
+}
+
+// VerifyToken
+// 检查签名和时间戳, 时间戳与当前时间相差超过 ttl 时过期; ttl 内同一个 token 只能验证通过一次
+func (s *HMACSigner) VerifyToken(token string) ([]byte, error) {
+	return s.verifyToken(token, time.Now())
+}
+
+func (s *HMACSigner) verifyToken(token string, now time.Time) ([]byte, error) {
+	i := strings.LastIndexByte(token, '.')
+	if i < 0 {
+		return nil, ErrInvalidToken
+	}
+	signed := token[:i]
+	signature, err := base64.RawURLEncoding.DecodeString(token[i+1:])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	if err = s.Verify([]byte(signed), signature); err != nil {
+		return nil, err
+	}
+	parts := strings.Split(signed, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	ts, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	if age := now.Sub(time.Unix(ts, 0)); age > s.ttl || age < -s.ttl {
+		return nil, ErrTokenExpired
+	}
+	if s.replayed(parts[2], now) {
+		return nil, ErrTokenReplayed
+	}
+	return payload, nil
+}
+
+// replayed
+// nonce 只需要记住 2*ttl, 更早的 token 已经因为过期被拒绝
+func (s *HMACSigner) replayed(nonce string, now time.Time) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if now.Sub(s.swept) > s.ttl {
+		for k, at := range s.seen {
+			if now.Sub(at) > 2*s.ttl {
+				delete(s.seen, k)
+			}
+		}
+		s.swept = now
+	}
+	if _, ok := s.seen[nonce]; ok {
+		return true
+	}
+	s.seen[nonce] = now
+	return false
+}
+
+// NewHMACSigner
+// ttl 是 token 的有效期, 默认 5 分钟
+func NewHMACSigner(key []byte, ttl time.Duration) *HMACSigner {
+	if ttl <= 0 {
+		ttl = 5 * time.Minute
+	}
+	return &HMACSigner{
+		key:  bytes.Clone(key),
+		ttl:  ttl,
+		seen: make(map[string]time.Time),
+	}
+}
+
+type Ed25519Signer struct {
+	ed25519.PrivateKey
+}
+
+func (s *Ed25519Signer) Algorithm() string {
+	return EdDSA
+}
+
+func (s *Ed25519Signer) Sign(payload []byte) []byte {
+	return ed25519.Sign(s.PrivateKey, payload)
+}
+
+func (s *Ed25519Signer) Verify(payload, signature []byte) error {
+	return s.Verifier().Verify(payload, signature)
+}
+
+func (s *Ed25519Signer) Verifier() *Ed25519Verifier {
+	return &Ed25519Verifier{PublicKey: s.Public().(ed25519.PublicKey)}
+}
+
+type Ed25519Verifier struct {
+	ed25519.PublicKey
+}
+
+func (v *Ed25519Verifier) Algorithm() string {
+	return EdDSA
+}
+
+func (v *Ed25519Verifier) Verify(payload, signature []byte) error {
+	if !ed25519.Verify(v.PublicKey, payload, signature) {
+		return ErrSignature
+	}
+	return nil
+}
+
+// NewEd25519Signer
+// 使用 libp2p 的私钥, 例如 pubsub.LoadOrGenerateKey 加载的节点身份
+func NewEd25519Signer(key p2pcrypto.PrivKey) (*Ed25519Signer, error) {
+	if key.Type() != p2pcrypto.Ed25519 {
+		return nil, fmt.Errorf("%w: %s key", ErrAlgorithm, key.Type())
+	}
+	raw, err := key.Raw()
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("crypto: invalid ed25519 private key size %d", len(raw))
+	}
+	return &Ed25519Signer{PrivateKey: raw}, nil
+}
+
+// NewEd25519Verifier
+// 使用 libp2p 的公钥
+func NewEd25519Verifier(key p2pcrypto.PubKey) (*Ed25519Verifier, error) {
+	if key.Type() != p2pcrypto.Ed25519 {
+		return nil, fmt.Errorf("%w: %s key", ErrAlgorithm, key.Type())
+	}
+	raw, err := key.Raw()
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("crypto: invalid ed25519 public key size %d", len(raw))
+	}
+	return &Ed25519Verifier{PublicKey: raw}, nil
+}
+
+// NewPeerVerifier
+// Ed25519 的 peer ID 内嵌公钥, 可以直接用 peer ID 验证该节点的签名
+func NewPeerVerifier(id peer.ID) (*Ed25519Verifier, error) {
+	key, err := id.ExtractPublicKey()
+	if err != nil {
+		return nil, err
+	}
+	return NewEd25519Verifier(key)
+}
+
+type jwsHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// SignJWS
+// 生成 JWS compact serialization, payload 通常是 JSON 编码的 claims
+func SignJWS(signer Signer, payload []byte) (string, error) {
+	header, err := json.Marshal(jwsHeader{Alg: signer.Algorithm(), Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signer.Sign([]byte(signed))), nil
+}
+
+// VerifyJWS
+// 只使用 alg 与头部一致的 verifier, 不接受 none; 返回 payload
+func VerifyJWS(token string, verifiers ...Verifier) ([]byte, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+	rawHeader, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var header jwsHeader
+	if err = json.Unmarshal(rawHeader, &header); err != nil {
+		return nil, ErrInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	err = fmt.Errorf("%w: %q", ErrAlgorithm, header.Alg)
+	for _, verifier := range verifiers {
+		if verifier.Algorithm() != header.Alg {
+			continue
+		}
+		if err = verifier.Verify(signed, signature); err == nil {
+			return payload, nil
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,111 @@
+package crypto
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	p2pcrypto "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestHMACToken(t *testing.T) {
+	signer := NewHMACSigner([]byte("secret"), time.Minute)
+	now := time.Now()
+	token, err := signer.token([]byte(`{"event":"push"}`), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := signer.verifyToken(token, now.Add(time.Second))
+	if err != nil || string(payload) != `{"event":"push"}` {
+		t.Fatalf("verify %q %v", payload, err)
+	}
+	if _, err = signer.verifyToken(token, now.Add(time.Second)); !errors.Is(err, ErrTokenReplayed) {
+		t.Fatalf("expected replay, got %v", err)
+	}
+	token, _ = signer.token([]byte("late"), now)
+	if _, err = signer.verifyToken(token, now.Add(2*time.Minute)); !errors.Is(err, ErrTokenExpired) {
+		t.Fatalf("expected expired, got %v", err)
+	}
+	if _, err = NewHMACSigner([]byte("other"), time.Minute).VerifyToken(token); !errors.Is(err, ErrSignature) {
+		t.Fatalf("expected invalid signature, got %v", err)
+	}
+	tampered := base64.RawURLEncoding.EncodeToString([]byte("evil")) + token[strings.IndexByte(token, '.'):]
+	if _, err = signer.verifyToken(tampered, now); !errors.Is(err, ErrSignature) {
+		t.Fatalf("expected invalid signature, got %v", err)
+	}
+}
+
+func TestEd25519(t *testing.T) {
+	priv, _, err := p2pcrypto.GenerateKeyPair(p2pcrypto.Ed25519, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := NewEd25519Signer(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := NewPeerVerifier(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := signer.Sign([]byte("payload"))
+	if err = verifier.Verify([]byte("payload"), signature); err != nil {
+		t.Fatal(err)
+	}
+	// 与 libp2p 自己的签名互相兼容
+	if ok, err := priv.GetPublic().Verify([]byte("payload"), signature); err != nil || !ok {
+		t.Fatalf("libp2p verify %v %v", ok, err)
+	}
+	if err = verifier.Verify([]byte("other"), signature); !errors.Is(err, ErrSignature) {
+		t.Fatalf("expected invalid signature, got %v", err)
+	}
+	ecdsa, _, err := p2pcrypto.GenerateKeyPair(p2pcrypto.ECDSA, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewEd25519Signer(ecdsa); !errors.Is(err, ErrAlgorithm) {
+		t.Fatalf("expected non ed25519 key to fail, got %v", err)
+	}
+}
+
+func TestJWS(t *testing.T) {
+	// RFC 7515 A.1
+	key, _ := base64.RawURLEncoding.DecodeString("AyM1SysPpbyDfgZld3umj1qzKObwVMkoqQ-EstJQLr_T-1qS0gZH75aKtMN3Yj0iPS4hcgUuTwjAzZr1Z9CAow")
+	hs := NewHMACSigner(key, 0)
+	token := "eyJ0eXAiOiJKV1QiLA0KICJhbGciOiJIUzI1NiJ9" +
+		".eyJpc3MiOiJqb2UiLA0KICJleHAiOjEzMDA4MTkzODAsDQogImh0dHA6Ly9leGFtcGxlLmNvbS9pc19yb290Ijp0cnVlfQ" +
+		".dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	payload, err := VerifyJWS(token, hs)
+	if err != nil || !strings.Contains(string(payload), `"iss":"joe"`) {
+		t.Fatalf("verify %q %v", payload, err)
+	}
+
+	priv, _, _ := p2pcrypto.GenerateKeyPair(p2pcrypto.Ed25519, -1)
+	ed, _ := NewEd25519Signer(priv)
+	for _, signer := range []Signer{hs, ed} {
+		token, err := SignJWS(signer, []byte(`{"sub":"node"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if payload, err = VerifyJWS(token, hs, ed.Verifier()); err != nil || string(payload) != `{"sub":"node"}` {
+			t.Fatalf("%s: verify %q %v", signer.Algorithm(), payload, err)
+		}
+	}
+
+	// alg 与 verifier 不一致或为 none 时拒绝
+	edToken, _ := SignJWS(ed, []byte("{}"))
+	if _, err = VerifyJWS(edToken, hs); !errors.Is(err, ErrAlgorithm) {
+		t.Fatalf("expected algorithm mismatch, got %v", err)
+	}
+	none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + ".e30."
+	if _, err = VerifyJWS(none, hs, ed.Verifier()); !errors.Is(err, ErrAlgorithm) {
+		t.Fatalf("expected none to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,230 @@
+package crypto
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/chacha20poly1305"
+	"io"
+)
+
+const (
+	StreamVersion   = 1
+	StreamChunkSize = 64 << 10
+	// streamPrefixSize nonce = 随机前缀(19) + 块序号(4) + 结束标记(1)
+	streamPrefixSize = chacha20poly1305.NonceSizeX - 5
+	streamHeaderSize = 2 + 4 + streamPrefixSize
+	maxStreamChunk   = 16 << 20
+)
+
+var (
+	ErrTruncated      = errors.New("crypto: stream truncated")
+	ErrStreamClosed   = errors.New("crypto: write to closed stream")
+	ErrStreamTooLarge = errors.New("crypto: stream too large")
+)
+
+// streamNonce
+// STREAM 构造: 每块的 nonce 由固定前缀、递增的块序号和是否最后一块组成, 重排、删除和截断都会导致认证失败
+func streamNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, 0, chacha20poly1305.NonceSizeX)
+	nonce = append(nonce, prefix...)
+	nonce = binary.BigEndian.AppendUint32(nonce, counter)
+	if last {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
+
+// EncryptWriter
+// 格式: 版本(1) + 算法(1) + 块大小(4) + nonce 前缀(19), 之后是若干个 块大小+16 的密文块,
+// 最后一块可以更短并且带结束标记. 头部作为每一块的附加数据. 必须调用 Close 写入最后一块
+type EncryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	header  []byte
+	prefix  []byte
+	size    int
+	buf     []byte
+	out     []byte
+	counter uint32
+	closed  bool
+}
+
+func (e *EncryptWriter) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, ErrStreamClosed
+	}
+	n := 0
+	for len(p) > 0 {
+		// 多缓存一个字节, 确认后面还有数据时才写出非最后一块
+		if len(e.buf) == e.size {
+			if err := e.flush(false); err != nil {
+				return n, err
+			}
+		}
+		m := copy(e.buf[len(e.buf):e.size], p)
+		e.buf = e.buf[:len(e.buf)+m]
+		p = p[m:]
+		n += m
+	}
+	return n, nil
+}
+
+func (e *EncryptWriter) flush(last bool) error {
+	if e.counter == 1<<32-1 && !last {
+		return ErrStreamTooLarge
+	}
+	e.out = e.aead.Seal(e.out[:0], streamNonce(e.prefix, e.counter, last), e.buf, e.header)
+	e.counter++
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(e.out)
+	return err
+}
+
+// Close
+// 写入带结束标记的最后一块, 不会关闭底层的 io.Writer
+func (e *EncryptWriter) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	return e.flush(true)
+}
+
+// NewEncryptWriter
+// key 必须是 32 字节, 使用 XChaCha20-Poly1305 和 StreamChunkSize 大小的块
+func NewEncryptWriter(w io.Writer, key []byte) (*EncryptWriter, error) {
+	return NewEncryptWriterSize(w, key, StreamChunkSize)
+}
+
+func NewEncryptWriterSize(w io.Writer, key []byte, chunkSize int) (*EncryptWriter, error) {
+	if chunkSize <= 0 || chunkSize > maxStreamChunk {
+		return nil, fmt.Errorf("crypto: invalid chunk size %d", chunkSize)
+	}
+	aead, err := XChaCha20Poly1305.AEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, streamHeaderSize)
+	header[0], header[1] = StreamVersion, byte(XChaCha20Poly1305)
+	binary.BigEndian.PutUint32(header[2:], uint32(chunkSize))
+	if _, err = rand.Read(header[6:]); err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(header); err != nil {
+		return nil, err
+	}
+	return &EncryptWriter{
+		w:      w,
+		aead:   aead,
+		header: header,
+		prefix: header[6:],
+		size:   chunkSize,
+		buf:    make([]byte, 0, chunkSize),
+		out:    make([]byte, 0, chunkSize+aead.Overhead()),
+	}, nil
+}
+
+// DecryptReader
+// 只返回通过认证的数据; 没有读到带结束标记的最后一块就遇到 EOF 时返回 ErrTruncated
+type DecryptReader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	header  []byte
+	prefix  []byte
+	chunk   []byte
+	buf     []byte
+	plain   []byte
+	counter uint32
+	done    bool
+	err     error
+}
+
+func (d *DecryptReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		if d.done {
+			return 0, io.EOF
+		}
+		d.err = d.next()
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+func (d *DecryptReader) next() error {
+	n, err := io.ReadFull(d.r, d.chunk)
+	switch {
+	case errors.Is(err, io.EOF):
+		return ErrTruncated
+	case errors.Is(err, io.ErrUnexpectedEOF):
+	case err != nil:
+		return err
+	}
+	chunk := d.chunk[:n]
+	// 读满一块时看后面是否还有数据, 没有时这一块应该是最后一块
+	last := n < len(d.chunk)
+	if !last {
+		if _, err = d.r.Peek(1); errors.Is(err, io.EOF) {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	}
+	if !last && d.counter == 1<<32-1 {
+		return ErrStreamTooLarge
+	}
+	plain, err := d.aead.Open(d.buf[:0], streamNonce(d.prefix, d.counter, last), chunk, d.header)
+	if err != nil {
+		if last {
+			if _, err = d.aead.Open(nil, streamNonce(d.prefix, d.counter, false), chunk, d.header); err == nil {
+				return ErrTruncated
+			}
+		}
+		return ErrDecrypt
+	}
+	d.counter++
+	d.plain = plain
+	d.done = last
+	return nil
+}
+
+// NewDecryptReader
+// 块大小从头部读取
+func NewDecryptReader(r io.Reader, key []byte) (*DecryptReader, error) {
+	aead, err := XChaCha20Poly1305.AEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, streamHeaderSize)
+	if _, err = io.ReadFull(r, header); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, ErrTruncated
+		}
+		return nil, err
+	}
+	if header[0] != StreamVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, header[0])
+	}
+	if Algorithm(header[1]) != XChaCha20Poly1305 {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, Algorithm(header[1]))
+	}
+	chunkSize := binary.BigEndian.Uint32(header[2:])
+	if chunkSize == 0 || chunkSize > maxStreamChunk {
+		return nil, fmt.Errorf("crypto: invalid chunk size %d", chunkSize)
+	}
+	return &DecryptReader{
+		r:      bufio.NewReader(r),
+		aead:   aead,
+		header: header,
+		prefix: header[6:],
+		chunk:  make([]byte, int(chunkSize)+aead.Overhead()),
+		buf:    make([]byte, 0, chunkSize),
+	}, nil
+}
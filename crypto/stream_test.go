@@ -0,0 +1,78 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+)
+
+func encryptStream(t *testing.T, key, data []byte, chunkSize int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewEncryptWriterSize(&buf, key, chunkSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 分多次写入, 覆盖跨块的情况
+	for len(data) > 0 {
+		n := min(len(data), 7)
+		if _, err = w.Write(data[:n]); err != nil {
+			t.Fatal(err)
+		}
+		data = data[n:]
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func decryptStream(key, data []byte) ([]byte, error) {
+	r, err := NewDecryptReader(bytes.NewReader(data), key)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestStream(t *testing.T) {
+	key := bytes.Repeat([]byte{3}, 32)
+	for _, size := range []int{0, 1, 31, 32, 33, 64, 100} {
+		data := make([]byte, size)
+		rand.Read(data)
+		sealed := encryptStream(t, key, data, 32)
+		plaintext, err := decryptStream(key, sealed)
+		if err != nil || !bytes.Equal(plaintext, data) {
+			t.Fatalf("size %d: %v", size, err)
+		}
+	}
+
+	data := make([]byte, 100)
+	sealed := encryptStream(t, key, data, 32)
+	chunk := 32 + 16
+	// 在块边界截断
+	if _, err := decryptStream(key, sealed[:streamHeaderSize+2*chunk]); !errors.Is(err, ErrTruncated) {
+		t.Fatalf("expected truncation, got %v", err)
+	}
+	if _, err := decryptStream(key, sealed[:streamHeaderSize]); !errors.Is(err, ErrTruncated) {
+		t.Fatalf("expected truncation, got %v", err)
+	}
+	// 交换两块
+	swapped := bytes.Clone(sealed)
+	copy(swapped[streamHeaderSize:], sealed[streamHeaderSize+chunk:streamHeaderSize+2*chunk])
+	copy(swapped[streamHeaderSize+chunk:], sealed[streamHeaderSize:streamHeaderSize+chunk])
+	if _, err := decryptStream(key, swapped); !errors.Is(err, ErrDecrypt) {
+		t.Fatalf("expected reordered chunks to fail, got %v", err)
+	}
+	if _, err := decryptStream(bytes.Repeat([]byte{4}, 32), sealed); !errors.Is(err, ErrDecrypt) {
+		t.Fatalf("expected wrong key to fail, got %v", err)
+	}
+
+	w, _ := NewEncryptWriter(io.Discard, key)
+	w.Close()
+	if _, err := w.Write([]byte("late")); !errors.Is(err, ErrStreamClosed) {
+		t.Fatalf("expected closed stream, got %v", err)
+	}
+}
@@ -0,0 +1,208 @@
+package lifecycle
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/yydsqu/tools/log"
+	sync2 "github.com/yydsqu/tools/sync"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	DefaultHookTimeout     = 10 * time.Second
+	DefaultShutdownTimeout = 30 * time.Second
+	ErrHookTimeout         = errors.New("lifecycle: stop hook timed out")
+)
+
+type StopFunc func(ctx context.Context) error
+
+// Closer
+// 适配 log.AsyncFileWriter、pubsub.PubSub 等只有 Close 方法的组件
+func Closer(c io.Closer) StopFunc {
+	return func(ctx context.Context) error {
+		return c.Close()
+	}
+}
+
+// Func
+// 适配 http.Client.CloseIdleConnections、log.Log.Close 等无返回值的方法
+func Func(fn func()) StopFunc {
+	return func(ctx context.Context) error {
+		fn()
+		return nil
+	}
+}
+
+type hook struct {
+	name    string
+	timeout time.Duration
+	stop    StopFunc
+}
+
+type HookResult struct {
+	Name     string
+	Duration time.Duration
+	Err      error
+	TimedOut bool
+}
+
+// ShutdownError
+// 列出失败或超时的 stop hook
+type ShutdownError struct {
+	Failed []HookResult
+}
+
+func (e *ShutdownError) Error() string {
+	parts := make([]string, 0, len(e.Failed))
+	for _, r := range e.Failed {
+		parts = append(parts, fmt.Sprintf("%s: %v", r.Name, r.Err))
+	}
+	return "lifecycle: shutdown failed: " + strings.Join(parts, "; ")
+}
+
+func (e *ShutdownError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Failed))
+	for _, r := range e.Failed {
+		errs = append(errs, r.Err)
+	}
+	return errs
+}
+
+// Manager
+// 按注册的逆序执行 stop hook, 先启动的组件最后停止
+type Manager struct {
+	mutex   sync.Mutex
+	hooks   []hook
+	logger  log.Logger
+	once    sync.Once
+	results []HookResult
+	err     error
+	done    chan struct{}
+}
+
+// Register
+// timeout <= 0 时使用 DefaultHookTimeout
+func (m *Manager) Register(name string, timeout time.Duration, stop StopFunc) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.hooks = append(m.hooks, hook{
+		name:    name,
+		timeout: cmp.Or(max(timeout, 0), DefaultHookTimeout),
+		stop:    stop,
+	})
+}
+
+// Shutdown
+// 只执行一次, 重复调用等待并返回第一次的结果
+func (m *Manager) Shutdown(ctx context.Context) ([]HookResult, error) {
+	m.once.Do(func() {
+		defer close(m.done)
+		m.mutex.Lock()
+		hooks := make([]hook, len(m.hooks))
+		copy(hooks, m.hooks)
+		m.mutex.Unlock()
+
+		var failed []HookResult
+		for i := len(hooks) - 1; i >= 0; i-- {
+			r := m.run(ctx, hooks[i])
+			m.results = append(m.results, r)
+			if r.Err != nil {
+				failed = append(failed, r)
+				m.logger.Error("stop hook failed", "hook", r.Name, "duration", r.Duration, "timeout", r.TimedOut, "err", r.Err)
+			} else {
+				m.logger.Info("stop hook done", "hook", r.Name, "duration", r.Duration)
+			}
+		}
+		if len(failed) > 0 {
+			m.err = &ShutdownError{Failed: failed}
+		}
+	})
+	select {
+	case <-m.done:
+		return m.results, m.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (m *Manager) run(ctx context.Context, h hook) HookResult {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+	var (
+		start = time.Now()
+		errCh = make(chan error, 1)
+	)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				errCh <- sync2.NewPanicError(h.name, r)
+			}
+		}()
+		errCh <- h.stop(ctx)
+	}()
+	r := HookResult{Name: h.name}
+	select {
+	case r.Err = <-errCh:
+		if errors.Is(r.Err, context.DeadlineExceeded) && ctx.Err() != nil {
+			r.TimedOut = true
+		}
+	case <-ctx.Done():
+		r.Err, r.TimedOut = fmt.Errorf("%w after %s", ErrHookTimeout, h.timeout), true
+	}
+	r.Duration = time.Since(start)
+	return r
+}
+
+// Wait
+// 阻塞到收到信号或 ctx 结束后执行 Shutdown, 默认监听 SIGINT 和 SIGTERM,
+// 关闭过程中再次收到信号直接退出进程
+func (m *Manager) Wait(ctx context.Context, timeout time.Duration, signals ...os.Signal) ([]HookResult, error) {
+	if len(signals) == 0 {
+		signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, signals...)
+	defer signal.Stop(ch)
+
+	select {
+	case sig := <-ch:
+		m.logger.Info("received signal, shutting down", "signal", sig)
+	case <-ctx.Done():
+		m.logger.Info("context done, shutting down", "err", context.Cause(ctx))
+	}
+
+	go func() {
+		select {
+		case sig := <-ch:
+			m.logger.Warn("received second signal, exiting", "signal", sig)
+			os.Exit(1)
+		case <-m.done:
+		}
+	}()
+
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cmp.Or(timeout, DefaultShutdownTimeout))
+	defer cancel()
+	return m.Shutdown(shutdownCtx)
+}
+
+func (m *Manager) Done() <-chan struct{} {
+	return m.done
+}
+
+func NewManager(logger log.Logger) *Manager {
+	if logger == nil {
+		logger = log.Root()
+	}
+	return &Manager{
+		logger: logger,
+		done:   make(chan struct{}),
+	}
+}
@@ -0,0 +1,83 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestManagerShutdown(t *testing.T) {
+	var (
+		m     = NewManager(nil)
+		mutex sync.Mutex
+		order []string
+		fail  = errors.New("fail")
+	)
+	appendOrder := func(name string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		order = append(order, name)
+	}
+	record := func(name string, err error) StopFunc {
+		return func(ctx context.Context) error {
+			appendOrder(name)
+			return err
+		}
+	}
+	m.Register("worker", 0, record("worker", nil))
+	m.Register("pubsub", 0, record("pubsub", fail))
+	m.Register("slow", 10*time.Millisecond, func(ctx context.Context) error {
+		appendOrder("slow")
+		time.Sleep(time.Second)
+		return nil
+	})
+	m.Register("http", 0, Func(func() { appendOrder("http") }))
+
+	results, err := m.Shutdown(context.Background())
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(results) != 4 {
+		t.Fatalf("got %d results", len(results))
+	}
+	for i, want := range []string{"http", "slow", "pubsub", "worker"} {
+		if order[i] != want {
+			t.Fatalf("hook %d ran %s, want %s", i, order[i], want)
+		}
+	}
+
+	var shutdownErr *ShutdownError
+	if !errors.As(err, &shutdownErr) || len(shutdownErr.Failed) != 2 {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !shutdownErr.Failed[0].TimedOut || !errors.Is(err, ErrHookTimeout) {
+		t.Fatalf("slow hook not reported as timed out: %+v", shutdownErr.Failed[0])
+	}
+	if !errors.Is(err, fail) {
+		t.Fatalf("failed hook error not wrapped: %v", err)
+	}
+
+	if again, _ := m.Shutdown(context.Background()); len(again) != 4 {
+		t.Fatal("second shutdown returned different results")
+	}
+}
+
+func TestManagerWait(t *testing.T) {
+	m := NewManager(nil)
+	stopped := make(chan struct{})
+	m.Register("worker", 0, func(ctx context.Context) error {
+		close(stopped)
+		return nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := m.Wait(ctx, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-stopped:
+	default:
+		t.Fatal("hook did not run")
+	}
+}
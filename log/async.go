@@ -120,6 +120,9 @@ func (w *AsyncFileWriter) write(msg []byte) {
 		date := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local).Add(DAY)
 		w.timeTimer.Reset(date.Sub(now))
 	default:
+	}
+	// 切换文件后也要写入当前消息, 否则每个文件的第一条日志会丢失
+	if w.fd != nil {
 		w.fd.Write(msg)
 	}
 }
@@ -0,0 +1,26 @@
+package log
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAsyncFileWriterFirstMessage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	w, err := NewAsyncFileWriter(path, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 第一次写入时计时器已经到期, 会先切换文件
+	w.Write([]byte("first\n"))
+	w.Write([]byte("second\n"))
+	w.Close()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "first\nsecond\n" {
+		t.Fatalf("unexpected content %q", data)
+	}
+}
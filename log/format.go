@@ -2,7 +2,9 @@ package log
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	sync2 "github.com/yydsqu/tools/sync"
 	"log/slog"
 	"math/big"
 	"reflect"
@@ -75,8 +77,12 @@ func (t *TerminalHandler) format(buf []byte, r slog.Record, useColor bool) []byt
 
 func (t *TerminalHandler) formatAttributes(buf *bytes.Buffer, r slog.Record, color string) {
 	var tmp = make([]byte, 40)
+	var stacks [][]byte
 	writeAttr := func(attr slog.Attr, first, last bool) {
 		buf.WriteByte(' ')
+		if stack := panicStack(attr.Value); stack != nil {
+			stacks = append(stacks, stack)
+		}
 
 		if color != "" {
 			buf.WriteString(color)
@@ -115,6 +121,36 @@ func (t *TerminalHandler) formatAttributes(buf *bytes.Buffer, r slog.Record, col
 		return true
 	})
 	buf.WriteByte('\n')
+	for _, stack := range stacks {
+		writeStack(buf, stack)
+	}
+}
+
+// panicStack
+// 属性值是 *sync.PanicError 时返回其堆栈
+func panicStack(v slog.Value) []byte {
+	if v.Kind() != slog.KindAny {
+		return nil
+	}
+	err, ok := v.Any().(error)
+	if !ok {
+		return nil
+	}
+	var panicErr *sync2.PanicError
+	if !errors.As(err, &panicErr) || len(panicErr.Stack) == 0 {
+		return nil
+	}
+	return panicErr.Stack
+}
+
+// writeStack
+// 堆栈按行缩进输出在日志行之后
+func writeStack(buf *bytes.Buffer, stack []byte) {
+	for _, line := range bytes.Split(bytes.TrimRight(stack, "\n"), []byte{'\n'}) {
+		buf.WriteByte('\t')
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
 }
 
 func FormatSlogValue(v slog.Value, tmp []byte) (result []byte) {
@@ -0,0 +1,29 @@
+package log
+
+import (
+	"bytes"
+	"fmt"
+	sync2 "github.com/yydsqu/tools/sync"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestPanicStack(t *testing.T) {
+	var buf bytes.Buffer
+	logger := &Log{inner: slog.New(NewTerminalHandler(&buf, false))}
+
+	err := fmt.Errorf("worker stopped: %w", &sync2.PanicError{Value: "boom", Stack: []byte("goroutine 1 [running]:\nmain.main()\n")})
+	logger.Error("crashed", "err", err)
+
+	lines := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected record and two stack lines, got %q", buf.String())
+	}
+	if !strings.Contains(lines[0], "panic: boom") {
+		t.Fatalf("record line missing panic value: %q", lines[0])
+	}
+	if lines[1] != "\tgoroutine 1 [running]:" || lines[2] != "\tmain.main()" {
+		t.Fatalf("unexpected stack lines: %q", lines[1:])
+	}
+}
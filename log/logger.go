@@ -116,7 +116,7 @@ func (l *Log) Error(msg string, ctx ...any) {
 func (l *Log) Fatal(msg string, ctx ...any) {
 	l.Write(12, msg, ctx...)
 	l.Close()
-	fmt.Fprintf(os.Stderr, msg, ctx)
+	fmt.Fprintf(os.Stderr, msg, ctx...)
 	os.Exit(1)
 }
 
@@ -0,0 +1,175 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrShipQueueFull = errors.New("log ship queue full")
+)
+
+// Attr
+// 发送前把属性值格式化为字符串, 接收端不需要知道原始类型
+type Attr struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// Record
+// 发送到集群的日志
+type Record struct {
+	Node    string    `json:"node"`
+	App     string    `json:"app"`
+	Time    time.Time `json:"time"`
+	Level   Level     `json:"level"`
+	Message string    `json:"message"`
+	Attrs   []Attr    `json:"attrs,omitempty"`
+}
+
+// Slog
+// 转换为 slog.Record, node 和 app 作为前两个属性
+func (r *Record) Slog() slog.Record {
+	record := slog.NewRecord(r.Time, slog.Level(r.Level), r.Message, 0)
+	record.AddAttrs(slog.String("node", r.Node), slog.String("app", r.App))
+	for _, attr := range r.Attrs {
+		record.AddAttrs(slog.String(attr.Key, attr.Value))
+	}
+	return record
+}
+
+// Publisher
+// 由调用方实现, 通常是把 Record 发布到 pubsub topic
+type Publisher func(ctx context.Context, record Record) error
+
+type shipper struct {
+	publish Publisher
+	queue   chan Record
+	dropped atomic.Uint64
+}
+
+func (s *shipper) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case record := <-s.queue:
+			if err := s.publish(ctx, record); err != nil && ctx.Err() == nil {
+				s.dropped.Add(1)
+				fmt.Fprintf(os.Stderr, "ship log error. err=%s\n", err)
+			}
+		}
+	}
+}
+
+// ShipHandler
+// 把 lvl 及以上级别的日志异步发送出去, 同时交给 root 处理;
+// 队列满时丢弃日志, 不会阻塞调用方
+type ShipHandler struct {
+	root    slog.Handler
+	lvl     slog.Level
+	node    string
+	app     string
+	group   string
+	attrs   []Attr
+	shipper *shipper
+}
+
+func (ship *ShipHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	return l >= ship.lvl || ship.root.Enabled(ctx, l)
+}
+
+func (ship *ShipHandler) Handle(ctx context.Context, record slog.Record) error {
+	var err error
+	if record.Level >= ship.lvl {
+		err = ship.ship(record)
+	}
+	if ship.root.Enabled(ctx, record.Level) {
+		err = errors.Join(err, ship.root.Handle(ctx, record))
+	}
+	return err
+}
+
+func (ship *ShipHandler) ship(record slog.Record) error {
+	r := Record{
+		Node:    ship.node,
+		App:     ship.app,
+		Time:    record.Time,
+		Level:   Level(record.Level),
+		Message: record.Message,
+		Attrs:   make([]Attr, 0, len(ship.attrs)+record.NumAttrs()),
+	}
+	r.Attrs = append(r.Attrs, ship.attrs...)
+	record.Attrs(func(attr slog.Attr) bool {
+		r.Attrs = ship.appendAttr(r.Attrs, attr)
+		return true
+	})
+	select {
+	case ship.shipper.queue <- r:
+		return nil
+	default:
+		ship.shipper.dropped.Add(1)
+		return ErrShipQueueFull
+	}
+}
+
+func (ship *ShipHandler) appendAttr(attrs []Attr, attr slog.Attr) []Attr {
+	return append(attrs, Attr{
+		Key:   ship.group + attr.Key,
+		Value: string(FormatSlogValue(attr.Value.Resolve(), nil)),
+	})
+}
+
+func (ship *ShipHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return ship
+	}
+	handler := *ship
+	handler.root = ship.root.WithAttrs(attrs)
+	handler.attrs = append([]Attr(nil), ship.attrs...)
+	for _, attr := range attrs {
+		handler.attrs = ship.appendAttr(handler.attrs, attr)
+	}
+	return &handler
+}
+
+func (ship *ShipHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return ship
+	}
+	handler := *ship
+	handler.root = ship.root.WithGroup(name)
+	handler.group = ship.group + name + "."
+	return &handler
+}
+
+// Dropped
+// 队列满或发送失败丢弃的日志数
+func (ship *ShipHandler) Dropped() uint64 {
+	return ship.shipper.dropped.Load()
+}
+
+// NewShipHandler
+// ctx 取消后停止发送, queueSize 默认 1024
+func NewShipHandler(ctx context.Context, root slog.Handler, lvl Level, queueSize int, publish Publisher) *ShipHandler {
+	if queueSize <= 0 {
+		queueSize = 1024
+	}
+	s := &shipper{
+		publish: publish,
+		queue:   make(chan Record, queueSize),
+	}
+	go s.run(ctx)
+	return &ShipHandler{
+		root:    root,
+		lvl:     slog.Level(lvl),
+		node:    Hostname,
+		app:     ExecutableName(),
+		shipper: s,
+	}
+}
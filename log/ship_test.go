@@ -0,0 +1,60 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShipHandler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var (
+		out     bytes.Buffer
+		records = make(chan Record, 4)
+		handler = NewShipHandler(ctx, NewTerminalHandlerWithLevel(&out, LevelTrace, false), LevelWarn, 0, func(ctx context.Context, record Record) error {
+			records <- record
+			return nil
+		})
+		logger = slog.New(handler).With("app", "test").WithGroup("req")
+	)
+	logger.Info("skipped")
+	logger.Error("failed", "id", 7)
+
+	select {
+	case record := <-records:
+		if record.Message != "failed" || record.Level != LevelError || record.Node != Hostname {
+			t.Fatalf("unexpected record %+v", record)
+		}
+		if len(record.Attrs) != 2 || record.Attrs[0] != (Attr{Key: "app", Value: "test"}) || record.Attrs[1] != (Attr{Key: "req.id", Value: "7"}) {
+			t.Fatalf("unexpected attrs %+v", record.Attrs)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("record not shipped")
+	}
+	select {
+	case record := <-records:
+		t.Fatalf("unexpected record %+v", record)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if !strings.Contains(out.String(), "skipped") || !strings.Contains(out.String(), "failed") {
+		t.Fatalf("root handler output %q", out.String())
+	}
+}
+
+func TestShipHandlerQueueFull(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	handler := NewShipHandler(ctx, NewTerminalHandlerWithLevel(&bytes.Buffer{}, LevelError, false), LevelInfo, 1, func(ctx context.Context, record Record) error {
+		return nil
+	})
+	logger := slog.New(handler)
+	logger.Info("first")
+	logger.Info("second")
+	if handler.Dropped() != 1 {
+		t.Fatalf("dropped %d", handler.Dropped())
+	}
+}
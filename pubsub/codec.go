@@ -0,0 +1,129 @@
+package pubsub
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/protobuf/proto"
+	"reflect"
+)
+
+var (
+	JSON  Codec = jsonCodec{}
+	Proto Codec = protoCodec{}
+	CBOR  Codec = cborCodec{}
+)
+
+// Codec
+// Unmarshal 的 v 必须是指针
+type Codec interface {
+	Name() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+type jsonCodec struct {
+}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+type cborCodec struct {
+}
+
+func (cborCodec) Name() string {
+	return "cbor"
+}
+
+func (cborCodec) Marshal(v any) ([]byte, error) {
+	return cbor.Marshal(v)
+}
+
+func (cborCodec) Unmarshal(data []byte, v any) error {
+	return cbor.Unmarshal(data, v)
+}
+
+type protoCodec struct {
+}
+
+func (protoCodec) Name() string {
+	return "proto"
+}
+
+func (protoCodec) Marshal(v any) ([]byte, error) {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("proto codec: %T is not a proto.Message", v)
+	}
+	return proto.Marshal(msg)
+}
+
+// Unmarshal
+// TypedTopic[*pb.Msg] 解码时传入的是 **pb.Msg, 需要先分配消息
+func (protoCodec) Unmarshal(data []byte, v any) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Pointer {
+			return fmt.Errorf("proto codec: %T is not a proto.Message", v)
+		}
+		if rv.Elem().IsNil() {
+			rv.Elem().Set(reflect.New(rv.Elem().Type().Elem()))
+		}
+		if msg, ok = rv.Elem().Interface().(proto.Message); !ok {
+			return fmt.Errorf("proto codec: %T is not a proto.Message", v)
+		}
+	}
+	return proto.Unmarshal(data, msg)
+}
+
+type zstdCodec struct {
+	codec   Codec
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+}
+
+func (c *zstdCodec) Name() string {
+	return c.codec.Name() + "+zstd"
+}
+
+func (c *zstdCodec) Marshal(v any) ([]byte, error) {
+	data, err := c.codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return c.encoder.EncodeAll(data, nil), nil
+}
+
+func (c *zstdCodec) Unmarshal(data []byte, v any) error {
+	raw, err := c.decoder.DecodeAll(data, nil)
+	if err != nil {
+		return fmt.Errorf("zstd decode: %w", err)
+	}
+	return c.codec.Unmarshal(raw, v)
+}
+
+// Zstd
+// 在 codec 外层做 zstd 压缩, 解压后大小限制为 maxSize 防止解压炸弹, <= 0 时为 1MB
+func Zstd(codec Codec, maxSize int) Codec {
+	if maxSize <= 0 {
+		maxSize = 1 << 20
+	}
+	encoder, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault))
+	decoder, _ := zstd.NewReader(nil, zstd.WithDecoderMaxMemory(uint64(maxSize)), zstd.WithDecoderConcurrency(0))
+	return &zstdCodec{
+		codec:   codec,
+		encoder: encoder,
+		decoder: decoder,
+	}
+}
@@ -0,0 +1,89 @@
+package pubsub
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/multiformats/go-multiaddr"
+	"time"
+)
+
+const (
+	staticTag = "static"
+)
+
+// DiscoveryConfig
+// 已连接节点数达到 MinPeers 后 DHT 发现间隔从 Interval 逐步退避到 MaxInterval,
+// StaticPeers 不受退避影响, 每个 Interval 检查一次断开的静态节点并重连
+type DiscoveryConfig struct {
+	Namespace   string   `json:"namespace" toml:"namespace" yaml:"namespace"`
+	Interval    Duration `json:"interval" toml:"interval" yaml:"interval"`
+	MaxInterval Duration `json:"max_interval" toml:"max_interval" yaml:"max_interval"`
+	MinPeers    int      `json:"min_peers" toml:"min_peers" yaml:"min_peers"`
+	MDNS        bool     `json:"mdns" toml:"mdns" yaml:"mdns"`
+	StaticPeers []string `json:"static_peers" toml:"static_peers" yaml:"static_peers"`
+}
+
+func (conf DiscoveryConfig) withDefaults() DiscoveryConfig {
+	conf.Namespace = cmp.Or(conf.Namespace, NS)
+	conf.Interval = cmp.Or(conf.Interval, Duration(30*time.Second))
+	conf.MaxInterval = max(cmp.Or(conf.MaxInterval, 10*conf.Interval), conf.Interval)
+	conf.MinPeers = cmp.Or(conf.MinPeers, 3)
+	return conf
+}
+
+func parseAddrInfos(addrs []string) ([]peer.AddrInfo, error) {
+	var infos []peer.AddrInfo
+	for _, addr := range addrs {
+		maddr, err := multiaddr.NewMultiaddr(addr)
+		if err != nil {
+			return nil, fmt.Errorf("parse address %q: %w", addr, err)
+		}
+		info, err := peer.AddrInfoFromP2pAddr(maddr)
+		if err != nil {
+			return nil, fmt.Errorf("parse address %q: %w", addr, err)
+		}
+		infos = append(infos, *info)
+	}
+	return infos, nil
+}
+
+// HandlePeerFound
+// mDNS 发现的局域网节点
+func (pubSub *PubSub) HandlePeerFound(info peer.AddrInfo) {
+	if info.ID == pubSub.host.ID() || pubSub.host.Network().Connectedness(info.ID) == network.Connected {
+		return
+	}
+	ctx, cancel := context.WithTimeout(pubSub.ctx, 10*time.Second)
+	defer cancel()
+	if err := pubSub.host.Connect(ctx, info); err != nil {
+		pubSub.logger.Trace("connect mdns peer failure", "id", info.ID, "err", err)
+		return
+	}
+	pubSub.logger.Trace("discovered and connected to mdns peer", "id", info.ID)
+}
+
+func (pubSub *PubSub) connectStatic() {
+	for _, info := range pubSub.static {
+		if pubSub.host.Network().Connectedness(info.ID) == network.Connected {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(pubSub.ctx, 10*time.Second)
+		if err := pubSub.host.Connect(ctx, info); err != nil {
+			pubSub.logger.Trace("connect static peer failure", "id", info.ID, "err", err)
+		}
+		cancel()
+	}
+}
+
+// protectStatic
+// 静态节点的地址永久保存, 并且不会被 conn manager 裁剪
+func (pubSub *PubSub) protectStatic() {
+	for _, info := range pubSub.static {
+		pubSub.host.Peerstore().AddAddrs(info.ID, info.Addrs, peerstore.PermanentAddrTTL)
+		pubSub.host.ConnManager().Protect(info.ID, staticTag)
+	}
+}
@@ -0,0 +1,57 @@
+package pubsub
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/yydsqu/tools/log"
+)
+
+func TestDiscoveryConfig(t *testing.T) {
+	conf := DiscoveryConfig{Interval: Duration(time.Minute), MaxInterval: Duration(time.Second)}.withDefaults()
+	if conf.Namespace != NS || conf.MaxInterval != Duration(time.Minute) || conf.MinPeers != 3 {
+		t.Fatalf("unexpected defaults %+v", conf)
+	}
+	conf = DiscoveryConfig{Namespace: "cluster"}.withDefaults()
+	if conf.Namespace != "cluster" || conf.Interval != Duration(30*time.Second) || conf.MaxInterval != Duration(5*time.Minute) {
+		t.Fatalf("unexpected defaults %+v", conf)
+	}
+	if _, err := parseAddrInfos([]string{"/ip4/127.0.0.1/tcp/1"}); err == nil {
+		t.Fatal("expected address without peer id to fail")
+	}
+}
+
+func TestStaticPeers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	remote := newTestNodes(t, ctx, 1)[0]
+	dir := t.TempDir()
+	node, err := NewPubSub(ctx, log.Root(), &Config{
+		Identity: filepath.Join(dir, "identity"),
+		Listen:   []string{"/ip4/127.0.0.1/tcp/0"},
+		Discovery: DiscoveryConfig{
+			Interval:    Duration(100 * time.Millisecond),
+			StaticPeers: remote.Addr(),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.Close()
+	if !node.Host().ConnManager().IsProtected(remote.Self(), staticTag) {
+		t.Fatal("expected static peer to be protected")
+	}
+	go node.Start()
+	connected := func() bool {
+		return node.Host().Network().Connectedness(remote.Self()) == network.Connected
+	}
+	waitFor(t, 5*time.Second, connected)
+
+	if err = node.Host().Network().ClosePeer(remote.Self()); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 5*time.Second, connected)
+}
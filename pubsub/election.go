@@ -0,0 +1,219 @@
+package pubsub
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/yydsqu/tools/log"
+	"github.com/yydsqu/tools/worker"
+	"sync"
+	"time"
+)
+
+const (
+	ElectionTopicPrefix = "election/"
+)
+
+var (
+	ErrLeadershipLost = errors.New("election: leadership lost")
+)
+
+type ElectionConfig struct {
+	Lease     Duration `json:"lease" toml:"lease" yaml:"lease"`
+	Heartbeat Duration `json:"heartbeat" toml:"heartbeat" yaml:"heartbeat"`
+}
+
+type heartbeat struct {
+	Leader bool `json:"leader"`
+}
+
+// Election
+// 基于 topic 心跳租约的选主, 候选节点周期性广播心跳, 租约内存在 leader 时跟随,
+// 否则 peer ID 最小的存活候选节点成为 leader. 网络分区期间可能短暂出现多个 leader
+type Election struct {
+	ctx     context.Context
+	pubSub  *PubSub
+	name    string
+	conf    ElectionConfig
+	worker  *worker.Worker
+	logger  log.Logger
+	topic   *pubsub.Topic
+	sub     *pubsub.Subscription
+	mutex   sync.Mutex
+	started time.Time
+	peers   map[peer.ID]time.Time
+	claims  map[peer.ID]time.Time
+	leader  peer.ID
+}
+
+func (e *Election) IsLeader() bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.leader == e.pubSub.Self()
+}
+
+// Leader
+// 当前已知的 leader, 尚未选出时为空
+func (e *Election) Leader() peer.ID {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.leader
+}
+
+// Start
+// 阻塞直到 ctx 结束, 退出前如果是 leader 会停止 worker
+func (e *Election) Start() error {
+	var (
+		ticker = time.NewTicker(time.Duration(e.conf.Heartbeat))
+		err    error
+	)
+	defer ticker.Stop()
+	if e.sub, err = e.topic.Subscribe(); err != nil {
+		return err
+	}
+	defer e.sub.Cancel()
+
+	e.mutex.Lock()
+	e.started = time.Now()
+	e.mutex.Unlock()
+
+	go e.receive()
+	e.publish()
+	for {
+		select {
+		case <-e.ctx.Done():
+			e.mutex.Lock()
+			e.apply(e.leader, "")
+			e.leader = ""
+			e.mutex.Unlock()
+			return nil
+		case <-ticker.C:
+			e.evaluate()
+			e.publish()
+		}
+	}
+}
+
+func (e *Election) receive() {
+	self := e.pubSub.Self()
+	for {
+		msg, err := e.sub.Next(e.ctx)
+		if err != nil {
+			return
+		}
+		from := msg.GetFrom()
+		if from == self {
+			continue
+		}
+		var hb heartbeat
+		if err = json.Unmarshal(msg.Data, &hb); err != nil {
+			e.logger.Trace("invalid election heartbeat", "peer", from, "err", err)
+			continue
+		}
+		now := time.Now()
+		e.mutex.Lock()
+		e.peers[from] = now
+		if hb.Leader {
+			e.claims[from] = now
+		} else {
+			delete(e.claims, from)
+		}
+		e.mutex.Unlock()
+		if hb.Leader {
+			e.evaluate()
+		}
+	}
+}
+
+func (e *Election) publish() {
+	data, _ := json.Marshal(heartbeat{Leader: e.IsLeader()})
+	if err := e.topic.Publish(e.ctx, data); err != nil && e.ctx.Err() == nil {
+		e.logger.Trace("publish election heartbeat failure", "err", err)
+	}
+}
+
+// evaluate
+// 租约内有其他 leader 时取 peer ID 最小的一个, 没有时等待一个租约周期后
+// 由 peer ID 最小的存活候选节点接任
+func (e *Election) evaluate() {
+	var (
+		self = e.pubSub.Self()
+		now  = time.Now()
+	)
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	for id, seen := range e.peers {
+		if now.Sub(seen) > time.Duration(e.conf.Lease) {
+			delete(e.peers, id)
+			delete(e.claims, id)
+		}
+	}
+	var claimed peer.ID
+	for id := range e.claims {
+		if claimed == "" || id < claimed {
+			claimed = id
+		}
+	}
+	prev, next := e.leader, e.leader
+	switch {
+	case claimed != "" && (prev != self || claimed < self):
+		next = claimed
+	case prev == self:
+	case now.Sub(e.started) >= time.Duration(e.conf.Lease):
+		next = self
+		for id := range e.peers {
+			if id < next {
+				next = id
+			}
+		}
+		if next != self {
+			// 最小的候选节点还未声明, 等它在下一轮接任
+			next = ""
+		}
+	default:
+		next = ""
+	}
+	e.leader = next
+	e.apply(prev, next)
+}
+
+// apply
+// 持有 mutex 时调用, 保证 worker 的启停顺序与 leader 变化顺序一致
+func (e *Election) apply(prev, next peer.ID) {
+	self := e.pubSub.Self()
+	switch {
+	case prev != self && next == self:
+		e.logger.Info("became leader", "election", e.name)
+		e.worker.Start()
+	case prev == self && next != self:
+		e.logger.Warn("leadership lost", "election", e.name, "leader", next)
+		e.worker.Stop(ErrLeadershipLost)
+	case prev != next:
+		e.logger.Debug("leader changed", "election", e.name, "leader", next)
+	}
+}
+
+// NewElection
+// 同一 PubSub 上每个 name 只能创建一个 Election, handle 只在本节点是 leader 时运行
+func NewElection(ctx context.Context, pubSub *PubSub, name string, conf ElectionConfig, w *worker.Worker) (*Election, error) {
+	conf.Lease = cmp.Or(conf.Lease, Duration(10*time.Second))
+	conf.Heartbeat = cmp.Or(conf.Heartbeat, conf.Lease/3)
+	topic, err := pubSub.Topic(ElectionTopicPrefix + name)
+	if err != nil {
+		return nil, err
+	}
+	return &Election{
+		ctx:    ctx,
+		pubSub: pubSub,
+		name:   name,
+		conf:   conf,
+		worker: w,
+		logger: pubSub.logger,
+		topic:  topic,
+		peers:  make(map[peer.ID]time.Time),
+		claims: make(map[peer.ID]time.Time),
+	}, nil
+}
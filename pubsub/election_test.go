@@ -0,0 +1,74 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/yydsqu/tools/worker"
+)
+
+func TestElection(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nodes := newTestNodes(t, ctx, 3)
+
+	var (
+		elections = make([]*Election, len(nodes))
+		workers   = make([]*worker.Worker, len(nodes))
+		cancels   = make([]context.CancelFunc, len(nodes))
+	)
+	for i, node := range nodes {
+		workers[i] = worker.NewWorker(func(ctx context.Context) {
+			<-ctx.Done()
+		})
+		var electionCtx context.Context
+		electionCtx, cancels[i] = context.WithCancel(ctx)
+		election, err := NewElection(electionCtx, node, "singleton", ElectionConfig{
+			Lease:     Duration(500 * time.Millisecond),
+			Heartbeat: Duration(50 * time.Millisecond),
+		}, workers[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		elections[i] = election
+		go election.Start()
+	}
+
+	stopped := -1
+	leader := func() int {
+		index := -1
+		for i, e := range elections {
+			if i == stopped {
+				continue
+			}
+			if e.IsLeader() {
+				if index >= 0 {
+					return -2
+				}
+				index = i
+			}
+		}
+		if index >= 0 {
+			for i, e := range elections {
+				if i != stopped && e.Leader() != nodes[index].Self() {
+					return -1
+				}
+			}
+		}
+		return index
+	}
+
+	waitFor(t, 10*time.Second, func() bool { return leader() >= 0 })
+	first := leader()
+	for i, w := range workers {
+		if w.Status() != (i == first) {
+			t.Fatalf("worker %d running=%v, leader is %d", i, w.Status(), first)
+		}
+	}
+
+	stopped = first
+	cancels[first]()
+	waitFor(t, 10*time.Second, func() bool { return leader() >= 0 })
+	waitFor(t, time.Second, func() bool { return !workers[first].Status() })
+}
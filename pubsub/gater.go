@@ -0,0 +1,137 @@
+package pubsub
+
+import (
+	"fmt"
+	"github.com/libp2p/go-libp2p/core/control"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"net/netip"
+	"sync"
+)
+
+// GaterConfig
+// Deny 优先于 Allow, Allow 为空表示不限制
+type GaterConfig struct {
+	AllowPeers []string `json:"allow_peers" toml:"allow_peers" yaml:"allow_peers"`
+	DenyPeers  []string `json:"deny_peers" toml:"deny_peers" yaml:"deny_peers"`
+	AllowCIDRs []string `json:"allow_cidrs" toml:"allow_cidrs" yaml:"allow_cidrs"`
+	DenyCIDRs  []string `json:"deny_cidrs" toml:"deny_cidrs" yaml:"deny_cidrs"`
+}
+
+func (conf *GaterConfig) Enabled() bool {
+	return len(conf.AllowPeers)+len(conf.DenyPeers)+len(conf.AllowCIDRs)+len(conf.DenyCIDRs) > 0
+}
+
+// Gater
+// 按 peer ID 和 IP 段过滤连接, 不含 IP 的地址(dns、relay)不受 CIDR 规则限制
+type Gater struct {
+	mutex      sync.RWMutex
+	allowPeers map[peer.ID]struct{}
+	denyPeers  map[peer.ID]struct{}
+	allowCIDRs []netip.Prefix
+	denyCIDRs  []netip.Prefix
+}
+
+func (g *Gater) DenyPeer(id peer.ID) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.denyPeers == nil {
+		g.denyPeers = make(map[peer.ID]struct{})
+	}
+	g.denyPeers[id] = struct{}{}
+}
+
+func (g *Gater) AllowedPeer(id peer.ID) bool {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+	if _, ok := g.denyPeers[id]; ok {
+		return false
+	}
+	if g.allowPeers == nil {
+		return true
+	}
+	_, ok := g.allowPeers[id]
+	return ok
+}
+
+func (g *Gater) AllowedAddr(addr multiaddr.Multiaddr) bool {
+	ip, err := manet.ToIP(addr)
+	if err != nil {
+		return true
+	}
+	ipAddr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return true
+	}
+	ipAddr = ipAddr.Unmap()
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+	for _, prefix := range g.denyCIDRs {
+		if prefix.Contains(ipAddr) {
+			return false
+		}
+	}
+	if len(g.allowCIDRs) == 0 {
+		return true
+	}
+	for _, prefix := range g.allowCIDRs {
+		if prefix.Contains(ipAddr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *Gater) InterceptPeerDial(id peer.ID) bool {
+	return g.AllowedPeer(id)
+}
+
+func (g *Gater) InterceptAddrDial(id peer.ID, addr multiaddr.Multiaddr) bool {
+	return g.AllowedPeer(id) && g.AllowedAddr(addr)
+}
+
+func (g *Gater) InterceptAccept(addrs network.ConnMultiaddrs) bool {
+	return g.AllowedAddr(addrs.RemoteMultiaddr())
+}
+
+func (g *Gater) InterceptSecured(dir network.Direction, id peer.ID, addrs network.ConnMultiaddrs) bool {
+	return g.AllowedPeer(id)
+}
+
+func (g *Gater) InterceptUpgraded(network.Conn) (bool, control.DisconnectReason) {
+	return true, 0
+}
+
+func parsePrefixes(cidrs []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("parse cidr %q: %w", cidr, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+func NewGater(conf GaterConfig) (*Gater, error) {
+	var (
+		g   = &Gater{}
+		err error
+	)
+	if g.allowPeers, err = decodePeers(conf.AllowPeers); err != nil {
+		return nil, fmt.Errorf("allow peers: %w", err)
+	}
+	if g.denyPeers, err = decodePeers(conf.DenyPeers); err != nil {
+		return nil, fmt.Errorf("deny peers: %w", err)
+	}
+	if g.allowCIDRs, err = parsePrefixes(conf.AllowCIDRs); err != nil {
+		return nil, fmt.Errorf("allow cidrs: %w", err)
+	}
+	if g.denyCIDRs, err = parsePrefixes(conf.DenyCIDRs); err != nil {
+		return nil, fmt.Errorf("deny cidrs: %w", err)
+	}
+	return g, nil
+}
@@ -0,0 +1,91 @@
+package pubsub
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/yydsqu/tools/log"
+)
+
+func newPeerID(t *testing.T) peer.ID {
+	t.Helper()
+	priv, _, err := crypto.GenerateEd25519Key(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestGater(t *testing.T) {
+	allowed, denied := newPeerID(t), newPeerID(t)
+	g, err := NewGater(GaterConfig{
+		AllowPeers: []string{allowed.String()},
+		AllowCIDRs: []string{"10.0.0.0/8", "::1/128"},
+		DenyCIDRs:  []string{"10.1.0.0/16"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !g.AllowedPeer(allowed) || g.AllowedPeer(denied) {
+		t.Fatal("unexpected peer rule")
+	}
+	g.DenyPeer(allowed)
+	if g.AllowedPeer(allowed) {
+		t.Fatal("deny should take precedence over allow")
+	}
+	for addr, want := range map[string]bool{
+		"/ip4/10.2.3.4/tcp/1":         true,
+		"/ip4/10.1.3.4/tcp/1":         false,
+		"/ip4/192.168.1.1/tcp/1":      false,
+		"/ip6/::1/tcp/1":              true,
+		"/ip6/::ffff:10.2.3.4/tcp/1":  true,
+		"/dns4/example.com/tcp/1":     true,
+		"/ip4/192.168.1.1/udp/1/quic": false,
+	} {
+		if got := g.AllowedAddr(multiaddr.StringCast(addr)); got != want {
+			t.Fatalf("%s: got %v", addr, got)
+		}
+	}
+	if _, err = NewGater(GaterConfig{DenyCIDRs: []string{"10.0.0.0"}}); err == nil {
+		t.Fatal("expected invalid cidr to fail")
+	}
+}
+
+func TestPrivateNetwork(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	psk := filepath.Join(t.TempDir(), "swarm.key")
+	nodes := newTestNodes(t, ctx, 2, func(conf *Config) {
+		conf.PSK = psk
+	})
+	target := peer.AddrInfo{ID: nodes[0].Self(), Addrs: nodes[0].Host().Addrs()}
+
+	dir := t.TempDir()
+	outsider, err := NewPubSub(ctx, log.Root(), &Config{
+		Identity: filepath.Join(dir, "identity"),
+		PSK:      filepath.Join(dir, "swarm.key"),
+		Listen:   []string{"/ip4/127.0.0.1/tcp/0"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer outsider.Close()
+	if err = outsider.Connect(ctx, target); err == nil {
+		t.Fatal("expected connection with a different psk to fail")
+	}
+
+	nodes[1].Host().Network().ClosePeer(nodes[0].Self())
+	nodes[1].Host().Peerstore().ClearAddrs(nodes[0].Self())
+	nodes[1].Gater().DenyPeer(nodes[0].Self())
+	if err = nodes[1].Connect(ctx, target); err == nil {
+		t.Fatal("expected connection to a denied peer to fail")
+	}
+}
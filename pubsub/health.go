@@ -0,0 +1,54 @@
+package pubsub
+
+import (
+	"encoding/json"
+	"github.com/libp2p/go-libp2p/core/network"
+	"net/http"
+)
+
+// Health
+// 没有配置 bootstrap 节点时 BootstrapReachable 为 true
+type Health struct {
+	Healthy            bool           `json:"healthy"`
+	Peers              int            `json:"peers"`
+	Bootstrap          int            `json:"bootstrap"`
+	BootstrapConnected int            `json:"bootstrap_connected"`
+	BootstrapReachable bool           `json:"bootstrap_reachable"`
+	RoutingTable       int            `json:"routing_table"`
+	Topics             map[string]int `json:"topics"`
+}
+
+// Health
+// 能连到 bootstrap 节点且至少有一个连接时认为健康
+func (pubSub *PubSub) Health() Health {
+	h := Health{
+		Peers:        len(pubSub.host.Network().Peers()),
+		Bootstrap:    len(pubSub.bootstrap),
+		RoutingTable: pubSub.dht.RoutingTable().Size(),
+		Topics:       make(map[string]int),
+	}
+	for _, info := range pubSub.bootstrap {
+		if pubSub.host.Network().Connectedness(info.ID) == network.Connected {
+			h.BootstrapConnected++
+		}
+	}
+	for _, topic := range pubSub.pubSub.GetTopics() {
+		h.Topics[topic] = len(pubSub.pubSub.ListPeers(topic))
+	}
+	h.BootstrapReachable = h.Bootstrap == 0 || h.BootstrapConnected > 0
+	h.Healthy = h.BootstrapReachable && h.Peers > 0
+	return h
+}
+
+// HealthHandler
+// 以 json 返回 Health, 不健康时状态码为 503
+func (pubSub *PubSub) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := pubSub.Health()
+		w.Header().Set("Content-Type", "application/json")
+		if !h.Healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(h)
+	})
+}
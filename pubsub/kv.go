@@ -0,0 +1,450 @@
+package pubsub
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/yydsqu/tools/log"
+	"iter"
+	"maps"
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	KVTopicPrefix = "kv/"
+)
+
+var (
+	ErrInvalidEntry = errors.New("pubsub: invalid kv entry")
+)
+
+type KVConfig struct {
+	// Interval 广播摘要做反熵同步的间隔
+	Interval Duration `json:"interval" toml:"interval" yaml:"interval"`
+	// Buffer 每个 Watch 的缓冲, 满了丢弃最旧的事件
+	Buffer int `json:"buffer" toml:"buffer" yaml:"buffer"`
+	// MaxSkew 允许的节点时钟误差, 时间超过本地时钟加 MaxSkew 的条目会被丢弃
+	MaxSkew Duration `json:"max_skew" toml:"max_skew" yaml:"max_skew"`
+}
+
+// KVEvent
+// Deleted 为 true 时 Value 为空, Peer 是最后修改该 key 的节点
+type KVEvent struct {
+	Key     string
+	Value   []byte
+	Deleted bool
+	Peer    peer.ID
+}
+
+// entry
+// LWW 寄存器, Time 相同时 Node 较大者胜出, 删除保留为墓碑.
+// Sig 是写入节点的签名, 条目经过其他节点转发或同步时仍能确认来源
+type entry struct {
+	Key     string `cbor:"k"`
+	Value   []byte `cbor:"v,omitempty"`
+	Deleted bool   `cbor:"x,omitempty"`
+	Time    int64  `cbor:"t"`
+	Node    []byte `cbor:"n"`
+	Sig     []byte `cbor:"s"`
+}
+
+func (e *entry) newer(other entry) bool {
+	if e.Time != other.Time {
+		return e.Time > other.Time
+	}
+	return bytes.Compare(e.Node, other.Node) > 0
+}
+
+// kvMessage
+// 修改时携带 Entries, 周期性广播只携带 Digest
+type kvMessage struct {
+	Entries []entry `cbor:"e,omitempty"`
+	Digest  []byte  `cbor:"d,omitempty"`
+}
+
+type syncRequest struct{}
+
+type watcher struct {
+	key string
+	ch  chan KVEvent
+}
+
+// KV
+// 基于 topic 广播的最终一致 key-value, 每个 key 是一个 LWW 寄存器.
+// 摘要不一致时通过 RPC 向对方拉取全部条目合并, 适合数据量不大的运行时配置
+type KV struct {
+	ctx      context.Context
+	pubSub   *PubSub
+	name     string
+	conf     KVConfig
+	logger   log.Logger
+	topic    *pubsub.Topic
+	guard    *guard
+	client   *Client[syncRequest, entry]
+	mutex    sync.Mutex
+	clock    int64
+	entries  map[string]entry
+	watchers map[*watcher]struct{}
+	syncing  map[peer.ID]bool
+}
+
+func (kv *KV) Name() string {
+	return kv.name
+}
+
+func (kv *KV) Get(key string) ([]byte, bool) {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+	e, ok := kv.entries[key]
+	if !ok || e.Deleted {
+		return nil, false
+	}
+	return slices.Clone(e.Value), true
+}
+
+// All
+// 按 key 排序遍历当前快照, 不包含已删除的 key
+func (kv *KV) All() iter.Seq2[string, []byte] {
+	kv.mutex.Lock()
+	snapshot := maps.Clone(kv.entries)
+	kv.mutex.Unlock()
+	return func(yield func(string, []byte) bool) {
+		for _, key := range slices.Sorted(maps.Keys(snapshot)) {
+			if e := snapshot[key]; !e.Deleted && !yield(key, slices.Clone(e.Value)) {
+				return
+			}
+		}
+	}
+}
+
+func (kv *KV) Set(ctx context.Context, key string, value []byte) error {
+	return kv.write(ctx, entry{Key: key, Value: slices.Clone(value)})
+}
+
+func (kv *KV) Delete(ctx context.Context, key string) error {
+	return kv.write(ctx, entry{Key: key, Deleted: true})
+}
+
+// write
+// 先写入本地再广播, 广播失败时由反熵同步补齐
+func (kv *KV) write(ctx context.Context, e entry) error {
+	kv.mutex.Lock()
+	next := kv.clock
+	if next < math.MaxInt64 {
+		next++
+	}
+	kv.clock = max(time.Now().UnixNano(), next)
+	e.Time = kv.clock
+	e, err := kv.sign(e)
+	if err != nil {
+		kv.mutex.Unlock()
+		return err
+	}
+	kv.apply(e)
+	kv.mutex.Unlock()
+	return kv.send(ctx, kvMessage{Entries: []entry{e}})
+}
+
+func (kv *KV) sign(e entry) (entry, error) {
+	e.Node = []byte(kv.pubSub.Self())
+	sig, err := kv.pubSub.identity.Sign(kv.signed(e))
+	if err != nil {
+		return e, fmt.Errorf("sign kv entry: %w", err)
+	}
+	e.Sig = sig
+	return e, nil
+}
+
+// signed
+// 签名覆盖 KV 名称、key、时间、写入节点、是否删除和值
+func (kv *KV) signed(e entry) []byte {
+	data := make([]byte, 0, len(KVTopicPrefix)+len(kv.name)+len(e.Key)+len(e.Node)+len(e.Value)+32)
+	data = append(data, KVTopicPrefix+kv.name...)
+	data = binary.AppendUvarint(data, uint64(len(e.Key)))
+	data = append(data, e.Key...)
+	data = binary.BigEndian.AppendUint64(data, uint64(e.Time))
+	data = binary.AppendUvarint(data, uint64(len(e.Node)))
+	data = append(data, e.Node...)
+	if e.Deleted {
+		data = append(data, 1)
+	} else {
+		data = append(data, 0)
+	}
+	return append(data, e.Value...)
+}
+
+// verify
+// topic 单独配置了 Publishers 时只接受其中节点写入的条目
+func (kv *KV) verify(e entry) error {
+	node := peer.ID(e.Node)
+	if kv.guard.publishers != nil {
+		if _, ok := kv.guard.publishers[node]; !ok {
+			return fmt.Errorf("%w: %s", ErrUnauthorizedPublisher, node)
+		}
+	}
+	key := kv.pubSub.host.Peerstore().PubKey(node)
+	if key == nil {
+		return fmt.Errorf("%w: unknown public key of %s", ErrInvalidEntry, node)
+	}
+	if ok, err := key.Verify(kv.signed(e), e.Sig); err != nil || !ok {
+		return fmt.Errorf("%w: bad signature from %s", ErrInvalidEntry, node)
+	}
+	return nil
+}
+
+func (kv *KV) send(ctx context.Context, msg kvMessage) error {
+	data, err := CBOR.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return kv.topic.Publish(ctx, data)
+}
+
+// apply
+// 持有 mutex 时调用, 条目更新时通知 Watch
+func (kv *KV) apply(e entry) {
+	kv.clock = max(kv.clock, e.Time)
+	if old, ok := kv.entries[e.Key]; ok && !e.newer(old) {
+		return
+	}
+	kv.entries[e.Key] = e
+	event := KVEvent{Key: e.Key, Value: e.Value, Deleted: e.Deleted, Peer: peer.ID(e.Node)}
+	for w := range kv.watchers {
+		if w.key == e.Key {
+			notify(w.ch, event)
+		}
+	}
+}
+
+// notify
+// 只有 apply 会写入, 缓冲满时丢弃最旧的事件, 保证最新的值一定能送达
+func notify(ch chan KVEvent, event KVEvent) {
+	for {
+		select {
+		case ch <- event:
+			return
+		default:
+		}
+		select {
+		case <-ch:
+		default:
+		}
+	}
+}
+
+// Watch
+// 先发送 key 的当前值(如果存在), 之后每次变化发送一次, ctx 结束后关闭
+func (kv *KV) Watch(ctx context.Context, key string) <-chan KVEvent {
+	w := &watcher{key: key, ch: make(chan KVEvent, kv.conf.Buffer)}
+	kv.mutex.Lock()
+	if e, ok := kv.entries[key]; ok {
+		w.ch <- KVEvent{Key: e.Key, Value: e.Value, Deleted: e.Deleted, Peer: peer.ID(e.Node)}
+	}
+	kv.watchers[w] = struct{}{}
+	kv.mutex.Unlock()
+	context.AfterFunc(ctx, func() {
+		kv.mutex.Lock()
+		defer kv.mutex.Unlock()
+		delete(kv.watchers, w)
+		close(w.ch)
+	})
+	return w.ch
+}
+
+func (kv *KV) snapshot() []entry {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+	entries := slices.Collect(maps.Values(kv.entries))
+	slices.SortFunc(entries, func(a, b entry) int {
+		return cmp.Compare(a.Key, b.Key)
+	})
+	return entries
+}
+
+// digest
+// 对按 key 排序的 (key, time, node) 求哈希, 相同说明两边状态一致
+func (kv *KV) digest() []byte {
+	h := sha256.New()
+	for _, e := range kv.snapshot() {
+		h.Write(binary.AppendUvarint(nil, uint64(len(e.Key))))
+		h.Write([]byte(e.Key))
+		h.Write(binary.BigEndian.AppendUint64(nil, uint64(e.Time)))
+		h.Write(e.Node)
+	}
+	return h.Sum(nil)
+}
+
+// Start
+// 阻塞直到 ctx 结束
+func (kv *KV) Start() error {
+	sub, err := kv.topic.Subscribe()
+	if err != nil {
+		return err
+	}
+	defer sub.Cancel()
+	go kv.receive(sub)
+
+	ticker := time.NewTicker(time.Duration(kv.conf.Interval))
+	defer ticker.Stop()
+	for {
+		if err = kv.send(kv.ctx, kvMessage{Digest: kv.digest()}); err != nil && kv.ctx.Err() == nil {
+			kv.logger.Trace("publish kv digest failure", "name", kv.name, "err", err)
+		}
+		select {
+		case <-kv.ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (kv *KV) receive(sub *pubsub.Subscription) {
+	self := kv.pubSub.Self()
+	for {
+		msg, err := sub.Next(kv.ctx)
+		if err != nil {
+			return
+		}
+		if msg.GetFrom() == self {
+			continue
+		}
+		var m kvMessage
+		if err = CBOR.Unmarshal(msg.Data, &m); err != nil {
+			kv.logger.Trace("invalid kv message", "name", kv.name, "peer", msg.GetFrom(), "err", err)
+			continue
+		}
+		kv.merge(m.Entries)
+		if m.Digest != nil && !bytes.Equal(m.Digest, kv.digest()) {
+			kv.sync(msg.GetFrom())
+		}
+	}
+}
+
+// merge
+// 同步拉取的条目可能由任意节点转发, 逐条验证写入节点的签名.
+// 丢弃时间超前太多的条目, 避免一个错误的时钟让之后所有本地写入都无法胜出
+func (kv *KV) merge(entries []entry) {
+	entries = slices.DeleteFunc(entries, func(e entry) bool {
+		if err := kv.verify(e); err != nil {
+			kv.logger.Debug("drop invalid kv entry", "name", kv.name, "key", e.Key, "err", err)
+			return true
+		}
+		return false
+	})
+	limit := time.Now().Add(time.Duration(kv.conf.MaxSkew)).UnixNano()
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+	for _, e := range entries {
+		if e.Time > limit {
+			kv.logger.Debug("drop kv entry from the future", "name", kv.name, "key", e.Key, "node", peer.ID(e.Node), "time", e.Time)
+			continue
+		}
+		kv.apply(e)
+	}
+}
+
+// validate
+// 广播的条目只能由写入它的节点发出, 不签名的策略下没有 from, 只验证条目的签名
+func (kv *KV) validate(ctx context.Context, msg *pubsub.Message) error {
+	var m kvMessage
+	if err := CBOR.Unmarshal(msg.Data, &m); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidEntry, err)
+	}
+	from := msg.GetFrom()
+	for _, e := range m.Entries {
+		if from != "" && peer.ID(e.Node) != from {
+			return fmt.Errorf("%w: entry of %s sent by %s", ErrInvalidEntry, peer.ID(e.Node), from)
+		}
+		if err := kv.verify(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sync
+// 拉取对方的全部条目, 每个节点同时只有一个同步请求; 对方缺少的条目由对方收到本节点摘要后拉取
+func (kv *KV) sync(from peer.ID) {
+	kv.mutex.Lock()
+	if kv.syncing[from] {
+		kv.mutex.Unlock()
+		return
+	}
+	kv.syncing[from] = true
+	kv.mutex.Unlock()
+	go func() {
+		defer func() {
+			kv.mutex.Lock()
+			delete(kv.syncing, from)
+			kv.mutex.Unlock()
+		}()
+		ctx, cancel := context.WithTimeout(kv.ctx, time.Duration(kv.conf.Interval))
+		defer cancel()
+		var entries []entry
+		for e, err := range kv.client.Stream(ctx, from, syncRequest{}) {
+			if err != nil {
+				kv.logger.Trace("sync kv failure", "name", kv.name, "peer", from, "err", err)
+				break
+			}
+			entries = append(entries, e)
+		}
+		kv.merge(entries)
+	}()
+}
+
+func (kv *KV) Close() error {
+	kv.pubSub.RemoveHandler(kv.client.Protocol())
+	return kv.topic.Close()
+}
+
+// NewKV
+// 同一 PubSub 上每个 name 只能创建一个 KV
+func NewKV(ctx context.Context, pubSub *PubSub, name string, conf KVConfig) (*KV, error) {
+	conf.Interval = cmp.Or(conf.Interval, Duration(30*time.Second))
+	conf.Buffer = cmp.Or(conf.Buffer, 16)
+	conf.MaxSkew = cmp.Or(conf.MaxSkew, Duration(time.Minute))
+	topic, err := pubSub.Topic(KVTopicPrefix + name)
+	if err != nil {
+		return nil, err
+	}
+	g, err := pubSub.topicGuard(KVTopicPrefix + name)
+	if err != nil {
+		topic.Close()
+		return nil, err
+	}
+	id := RPCProtocol(KVTopicPrefix + name)
+	kv := &KV{
+		ctx:      ctx,
+		pubSub:   pubSub,
+		name:     name,
+		conf:     conf,
+		logger:   pubSub.logger,
+		topic:    topic,
+		guard:    g,
+		client:   NewClient[syncRequest, entry](pubSub, id, CBOR),
+		entries:  make(map[string]entry),
+		watchers: make(map[*watcher]struct{}),
+		syncing:  make(map[peer.ID]bool),
+	}
+	HandleStream(pubSub, id, CBOR, func(ctx context.Context, from peer.ID, _ syncRequest, send func(entry) error) error {
+		for _, e := range kv.snapshot() {
+			if err := send(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	g.once.Do(func() {
+		g.add(kv.validate)
+	})
+	return kv, nil
+}
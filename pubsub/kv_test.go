@@ -0,0 +1,170 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	pb "github.com/libp2p/go-libp2p-pubsub/pb"
+)
+
+func startKV(t *testing.T, ctx context.Context, node *PubSub) *KV {
+	t.Helper()
+	kv, err := NewKV(ctx, node, "config", KVConfig{Interval: Duration(100 * time.Millisecond)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go kv.Start()
+	return kv
+}
+
+func TestKV(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nodes := newTestNodes(t, ctx, 3)
+	a, b := startKV(t, ctx, nodes[0]), startKV(t, ctx, nodes[1])
+	watch := b.Watch(ctx, "limit")
+	waitFor(t, 5*time.Second, func() bool {
+		return len(a.topic.ListPeers()) == 1
+	})
+
+	if err := a.Set(ctx, "limit", []byte("10")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case event := <-watch:
+		if string(event.Value) != "10" || event.Peer != nodes[0].Self() {
+			t.Fatalf("unexpected event %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch timeout")
+	}
+
+	// 后加入的节点通过反熵同步拿到已有的值
+	c := startKV(t, ctx, nodes[2])
+	waitFor(t, 5*time.Second, func() bool {
+		v, ok := c.Get("limit")
+		return ok && string(v) == "10"
+	})
+
+	// 并发写同一个 key 最终收敛到同一个值
+	b.Set(ctx, "proxy", []byte("b"))
+	c.Set(ctx, "proxy", []byte("c"))
+	waitFor(t, 5*time.Second, func() bool {
+		va, _ := a.Get("proxy")
+		vb, _ := b.Get("proxy")
+		vc, _ := c.Get("proxy")
+		return len(va) > 0 && string(va) == string(vb) && string(vb) == string(vc)
+	})
+
+	if err := c.Delete(ctx, "limit"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case event := <-watch:
+		if !event.Deleted || event.Peer != nodes[2].Self() {
+			t.Fatalf("unexpected event %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch timeout")
+	}
+	waitFor(t, 5*time.Second, func() bool {
+		_, ok := a.Get("limit")
+		return !ok
+	})
+	for key := range a.All() {
+		if key != "proxy" {
+			t.Fatalf("unexpected key %s", key)
+		}
+	}
+}
+
+func TestKVWatchOverflow(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	node := newTestNodes(t, ctx, 1)[0]
+	kv, err := NewKV(ctx, node, "overflow", KVConfig{Buffer: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	watchCtx, stop := context.WithCancel(ctx)
+	watch := kv.Watch(watchCtx, "key")
+	kv.Set(ctx, "key", []byte("1"))
+	kv.Set(ctx, "key", []byte("2"))
+	if event := <-watch; string(event.Value) != "2" {
+		t.Fatalf("expected latest value, got %q", event.Value)
+	}
+	stop()
+	waitFor(t, time.Second, func() bool {
+		_, ok := <-watch
+		return !ok
+	})
+}
+
+func TestKVRejectsBadEntries(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nodes := newTestNodes(t, ctx, 2)
+	kv, err := NewKV(ctx, nodes[0], "bounded", KVConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote, err := NewKV(ctx, nodes[1], "bounded", KVConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := func(e entry) entry {
+		e, err := remote.sign(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+	kv.merge([]entry{signed(entry{Key: "key", Value: []byte("future"), Time: math.MaxInt64})})
+	if _, ok := kv.Get("key"); ok {
+		t.Fatal("entry from the future applied")
+	}
+	kv.merge([]entry{signed(entry{Key: "key", Value: []byte("remote"), Time: time.Now().UnixNano()})})
+	if v, _ := kv.Get("key"); string(v) != "remote" {
+		t.Fatalf("signed remote entry not applied, got %q", v)
+	}
+	if err = kv.Set(ctx, "key", []byte("local")); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := kv.Get("key"); string(v) != "local" {
+		t.Fatalf("local write lost, got %q", v)
+	}
+
+	// 同步时任意节点都可能转发条目, 冒充写入节点或篡改内容的条目必须被丢弃
+	forged := signed(entry{Key: "key", Value: []byte("forged"), Time: time.Now().UnixNano()})
+	forged.Value = []byte("tampered")
+	unsigned := entry{Key: "other", Value: []byte("forged"), Time: time.Now().UnixNano(), Node: []byte(nodes[1].Self())}
+	kv.merge([]entry{forged, unsigned})
+	if v, _ := kv.Get("key"); string(v) != "local" {
+		t.Fatalf("tampered entry applied, got %q", v)
+	}
+	if _, ok := kv.Get("other"); ok {
+		t.Fatal("unsigned entry applied")
+	}
+
+	data, err := CBOR.Marshal(kvMessage{Entries: []entry{signed(entry{Key: "key", Time: 1})}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := &pubsub.Message{Message: &pb.Message{From: []byte(nodes[0].Self()), Data: data}}
+	if err = kv.validate(ctx, msg); !errors.Is(err, ErrInvalidEntry) {
+		t.Fatalf("entry of another node accepted: %v", err)
+	}
+	msg.From = []byte(nodes[1].Self())
+	if err = kv.validate(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	data, _ = CBOR.Marshal(kvMessage{Entries: []entry{forged}})
+	msg.Data = data
+	if err = kv.validate(ctx, msg); !errors.Is(err, ErrInvalidEntry) {
+		t.Fatalf("tampered entry accepted: %v", err)
+	}
+}
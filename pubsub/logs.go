@@ -0,0 +1,48 @@
+package pubsub
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"github.com/yydsqu/tools/log"
+	"io"
+	"log/slog"
+)
+
+const (
+	LogTopic = "cluster/logs"
+)
+
+// NewLogTopic
+// name 为空时使用 LogTopic, 发送端和收集端需要使用同一个 topic
+func NewLogTopic(pubSub *PubSub, name string) (*TypedTopic[log.Record], error) {
+	return NewTypedTopic[log.Record](pubSub, cmp.Or(name, LogTopic), JSON)
+}
+
+// LogPublisher
+// 配合 log.NewShipHandler 把日志发布到 topic
+//
+//	topic, _ := pubsub.NewLogTopic(node, "")
+//	handler := log.NewShipHandler(ctx, root, log.LevelError, 0, pubsub.LogPublisher(topic))
+func LogPublisher(topic *TypedTopic[log.Record]) log.Publisher {
+	return func(ctx context.Context, record log.Record) error {
+		return topic.Publish(ctx, record)
+	}
+}
+
+// CollectLogs
+// 订阅 topic 并把收到的日志写入 w (通常是 log.AsyncFileWriter), 每行带上来源节点名和 peer ID,
+// 阻塞直到 ctx 取消
+func CollectLogs(ctx context.Context, topic *TypedTopic[log.Record], w io.Writer) error {
+	messages, err := topic.Subscribe(ctx)
+	if err != nil {
+		return fmt.Errorf("subscribe %s: %w", topic.Name(), err)
+	}
+	handler := log.NewTerminalHandlerWithLevel(w, log.LevelTrace, false)
+	for msg := range messages {
+		record := msg.Value.Slog()
+		record.AddAttrs(slog.String("peer", msg.From.String()))
+		handler.Handle(ctx, record)
+	}
+	return nil
+}
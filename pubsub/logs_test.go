@@ -0,0 +1,61 @@
+package pubsub
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yydsqu/tools/log"
+)
+
+func TestCollectLogs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nodes := newTestNodes(t, ctx, 2)
+	pub, err := NewLogTopic(nodes[0], "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := NewLogTopic(nodes[1], "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "cluster.log")
+	writer, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	collected := make(chan struct{})
+	defer func() {
+		cancel()
+		<-collected
+		writer.Close()
+	}()
+	go func() {
+		defer close(collected)
+		CollectLogs(ctx, sub, writer)
+	}()
+	waitFor(t, 5*time.Second, func() bool {
+		return len(pub.Topic().ListPeers()) == 1
+	})
+
+	handler := log.NewShipHandler(ctx, log.NewTerminalHandlerWithLevel(os.Stdout, log.LevelError, false), log.LevelWarn, 0, LogPublisher(pub))
+	logger := slog.New(handler)
+	logger.Info("not shipped")
+	logger.Error("disk full", "path", "/data")
+
+	waitFor(t, 5*time.Second, func() bool {
+		data, _ := os.ReadFile(path)
+		return strings.Contains(string(data), "disk full")
+	})
+	data, _ := os.ReadFile(path)
+	line := string(data)
+	if strings.Contains(line, "not shipped") || !strings.Contains(line, "node="+log.Hostname) ||
+		!strings.Contains(line, "peer="+nodes[0].Self().String()) || !strings.Contains(line, "path=/data") {
+		t.Fatalf("unexpected log %q", line)
+	}
+}
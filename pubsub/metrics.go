@@ -0,0 +1,212 @@
+package pubsub
+
+import (
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yydsqu/tools/log"
+	"sync"
+)
+
+var (
+	messagesPublished = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "pubsub_messages_published_total",
+			Help: "Total number of messages published by this node, partitioned by topic.",
+			ConstLabels: map[string]string{
+				"nodename": log.Hostname,
+			},
+		},
+		[]string{"topic"},
+	)
+	messagesReceived = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "pubsub_messages_received_total",
+			Help: "Total number of messages received from other peers and delivered, partitioned by topic.",
+			ConstLabels: map[string]string{
+				"nodename": log.Hostname,
+			},
+		},
+		[]string{"topic"},
+	)
+	messagesDropped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "pubsub_messages_dropped_total",
+			Help: "Total number of messages dropped, partitioned by topic and reason.",
+			ConstLabels: map[string]string{
+				"nodename": log.Hostname,
+			},
+		},
+		[]string{"topic", "reason"},
+	)
+	rpcBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "pubsub_rpc_bytes_total",
+			Help: "Total number of pubsub rpc bytes, partitioned by direction.",
+			ConstLabels: map[string]string{
+				"nodename": log.Hostname,
+			},
+		},
+		[]string{"direction"},
+	)
+	collector = &pubSubCollector{
+		peers: prometheus.NewDesc(
+			"pubsub_connected_peers",
+			"Number of peers connected to the libp2p host.",
+			[]string{"self"},
+			prometheus.Labels{"nodename": log.Hostname},
+		),
+		topicPeers: prometheus.NewDesc(
+			"pubsub_topic_peers",
+			"Number of known peers subscribed to the topic.",
+			[]string{"self", "topic"},
+			prometheus.Labels{"nodename": log.Hostname},
+		),
+		mesh: prometheus.NewDesc(
+			"pubsub_mesh_peers",
+			"Number of peers in the gossipsub mesh of the topic.",
+			[]string{"self", "topic"},
+			prometheus.Labels{"nodename": log.Hostname},
+		),
+		routingTable: prometheus.NewDesc(
+			"pubsub_dht_routing_table_size",
+			"Number of peers in the DHT routing table.",
+			[]string{"self"},
+			prometheus.Labels{"nodename": log.Hostname},
+		),
+	}
+)
+
+type pubSubCollector struct {
+	peers        *prometheus.Desc
+	topicPeers   *prometheus.Desc
+	mesh         *prometheus.Desc
+	routingTable *prometheus.Desc
+	nodes        sync.Map
+}
+
+func (c *pubSubCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.peers
+	ch <- c.topicPeers
+	ch <- c.mesh
+	ch <- c.routingTable
+}
+
+func (c *pubSubCollector) Collect(ch chan<- prometheus.Metric) {
+	c.nodes.Range(func(key, value any) bool {
+		pub := value.(*PubSub)
+		self := key.(peer.ID).String()
+		ch <- prometheus.MustNewConstMetric(c.peers, prometheus.GaugeValue, float64(len(pub.host.Network().Peers())), self)
+		ch <- prometheus.MustNewConstMetric(c.routingTable, prometheus.GaugeValue, float64(pub.dht.RoutingTable().Size()), self)
+		for _, topic := range pub.pubSub.GetTopics() {
+			ch <- prometheus.MustNewConstMetric(c.topicPeers, prometheus.GaugeValue, float64(len(pub.pubSub.ListPeers(topic))), self, topic)
+		}
+		for topic, size := range pub.tracer.meshSize() {
+			ch <- prometheus.MustNewConstMetric(c.mesh, prometheus.GaugeValue, float64(size), self, topic)
+		}
+		return true
+	})
+}
+
+// tracer
+// 统计收发的消息和字节数, gossipsub 时记录每个 topic 的 mesh.
+// self 在创建 libp2p PubSub 之前设置, 之后只读
+type tracer struct {
+	self  peer.ID
+	mutex sync.Mutex
+	mesh  map[string]map[peer.ID]struct{}
+}
+
+func (t *tracer) meshSize() map[string]int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	sizes := make(map[string]int, len(t.mesh))
+	for topic, peers := range t.mesh {
+		sizes[topic] = len(peers)
+	}
+	return sizes
+}
+
+func (t *tracer) AddPeer(peer.ID, protocol.ID) {}
+
+func (t *tracer) RemovePeer(id peer.ID) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for _, peers := range t.mesh {
+		delete(peers, id)
+	}
+}
+
+func (t *tracer) Join(topic string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.mesh[topic] = make(map[peer.ID]struct{})
+}
+
+func (t *tracer) Leave(topic string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.mesh, topic)
+}
+
+func (t *tracer) Graft(id peer.ID, topic string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if peers, ok := t.mesh[topic]; ok {
+		peers[id] = struct{}{}
+	}
+}
+
+func (t *tracer) Prune(id peer.ID, topic string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.mesh[topic], id)
+}
+
+func (t *tracer) ValidateMessage(*pubsub.Message) {}
+
+// DeliverMessage
+// 本节点发布的消息不计入接收. libp2p 目前已经不会为这些消息调用 raw tracer, 这里显式检查, 不依赖这个实现细节
+func (t *tracer) DeliverMessage(msg *pubsub.Message) {
+	if msg.ReceivedFrom == t.self {
+		return
+	}
+	messagesReceived.WithLabelValues(msg.GetTopic()).Inc()
+}
+
+func (t *tracer) RejectMessage(msg *pubsub.Message, reason string) {
+	messagesDropped.WithLabelValues(msg.GetTopic(), reason).Inc()
+}
+
+func (t *tracer) DuplicateMessage(msg *pubsub.Message) {
+	messagesDropped.WithLabelValues(msg.GetTopic(), "duplicate").Inc()
+}
+
+func (t *tracer) ThrottlePeer(peer.ID) {}
+
+func (t *tracer) RecvRPC(rpc *pubsub.RPC) {
+	rpcBytes.WithLabelValues("in").Add(float64(rpc.Size()))
+}
+
+func (t *tracer) SendRPC(rpc *pubsub.RPC, _ peer.ID) {
+	rpcBytes.WithLabelValues("out").Add(float64(rpc.Size()))
+}
+
+func (t *tracer) DropRPC(rpc *pubsub.RPC, _ peer.ID) {
+	for _, msg := range rpc.GetPublish() {
+		messagesDropped.WithLabelValues(msg.GetTopic(), "queue_full").Inc()
+	}
+}
+
+func (t *tracer) UndeliverableMessage(msg *pubsub.Message) {
+	messagesDropped.WithLabelValues(msg.GetTopic(), "undeliverable").Inc()
+}
+
+func init() {
+	prometheus.Register(messagesPublished)
+	prometheus.Register(messagesReceived)
+	prometheus.Register(messagesDropped)
+	prometheus.Register(rpcBytes)
+	prometheus.Register(collector)
+}
@@ -0,0 +1,112 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nodes := newTestNodes(t, ctx, 2, func(conf *Config) {
+		conf.Router = RouterGossipSub
+	})
+	pub, err := nodes[0].Topic("metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := nodes[1].Topic("metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	subscription, err := sub.Subscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer subscription.Cancel()
+	own, err := pub.Subscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer own.Cancel()
+	// gossipsub 在 mesh 建立前发布的消息可能丢失
+	waitFor(t, 5*time.Second, func() bool {
+		return nodes[0].tracer.meshSize()["metrics"] == 1 && nodes[1].tracer.meshSize()["metrics"] == 1
+	})
+
+	var (
+		published = testutil.ToFloat64(messagesPublished.WithLabelValues("metrics"))
+		received  = testutil.ToFloat64(messagesReceived.WithLabelValues("metrics"))
+		bytesIn   = testutil.ToFloat64(rpcBytes.WithLabelValues("in"))
+	)
+	if err = pub.Publish(ctx, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = subscription.Next(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if v := testutil.ToFloat64(messagesPublished.WithLabelValues("metrics")) - published; v != 1 {
+		t.Fatalf("published %v", v)
+	}
+	if _, err = own.Next(ctx); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 5*time.Second, func() bool {
+		return testutil.ToFloat64(messagesReceived.WithLabelValues("metrics"))-received == 1
+	})
+	// 本节点发布后投递给自己的订阅不计入接收
+	time.Sleep(100 * time.Millisecond)
+	if v := testutil.ToFloat64(messagesReceived.WithLabelValues("metrics")) - received; v != 1 {
+		t.Fatalf("received %v", v)
+	}
+	if testutil.ToFloat64(rpcBytes.WithLabelValues("in")) <= bytesIn {
+		t.Fatal("expected received bytes to grow")
+	}
+	if n := testutil.CollectAndCount(collector, "pubsub_connected_peers"); n != 2 {
+		t.Fatalf("collected %d peer gauges", n)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nodes := newTestNodes(t, ctx, 2)
+
+	h := nodes[1].Health()
+	if !h.Healthy || !h.BootstrapReachable || h.Peers != 1 {
+		t.Fatalf("unexpected health %+v", h)
+	}
+
+	server := httptest.NewServer(nodes[1].HealthHandler())
+	defer server.Close()
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if err = json.NewDecoder(resp.Body).Decode(&h); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || !h.Healthy {
+		t.Fatalf("unexpected response %d %+v", resp.StatusCode, h)
+	}
+
+	if err = nodes[1].Host().Network().ClosePeer(nodes[0].Self()); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 5*time.Second, func() bool { return !nodes[1].Health().Healthy })
+	resp, err = http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected status %d", resp.StatusCode)
+	}
+}
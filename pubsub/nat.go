@@ -0,0 +1,131 @@
+package pubsub
+
+import (
+	"fmt"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"strings"
+)
+
+const (
+	ReachabilityPublic  = "public"
+	ReachabilityPrivate = "private"
+)
+
+// NATConfig
+// RelayClient 未设置 StaticRelays 时使用 Bootstrap 节点作为中继,
+// Announce 设置后替换对外公布的地址, Reachability 可强制指定 public 或 private
+type NATConfig struct {
+	PortMap      bool     `json:"port_map" toml:"port_map" yaml:"port_map"`
+	AutoNAT      bool     `json:"autonat" toml:"autonat" yaml:"autonat"`
+	HolePunching bool     `json:"hole_punching" toml:"hole_punching" yaml:"hole_punching"`
+	RelayClient  bool     `json:"relay_client" toml:"relay_client" yaml:"relay_client"`
+	RelayService bool     `json:"relay_service" toml:"relay_service" yaml:"relay_service"`
+	StaticRelays []string `json:"static_relays" toml:"static_relays" yaml:"static_relays"`
+	Announce     []string `json:"announce" toml:"announce" yaml:"announce"`
+	Reachability string   `json:"reachability" toml:"reachability" yaml:"reachability"`
+}
+
+// natOptions
+// 把 NATConfig 转换为 libp2p 选项
+func (conf *Config) natOptions() ([]libp2p.Option, error) {
+	var (
+		nat     = conf.NAT
+		options []libp2p.Option
+	)
+	if nat.PortMap {
+		options = append(options, libp2p.NATPortMap())
+	}
+	if nat.AutoNAT {
+		options = append(options, libp2p.EnableNATService(), libp2p.EnableAutoNATv2())
+	}
+	if nat.HolePunching {
+		options = append(options, libp2p.EnableHolePunching())
+	}
+	if nat.RelayService {
+		options = append(options, libp2p.EnableRelayService())
+	}
+	if nat.RelayClient {
+		relays, err := parseAddrInfos(nat.StaticRelays)
+		if err != nil {
+			return nil, fmt.Errorf("static relays: %w", err)
+		}
+		if len(relays) == 0 {
+			if relays, err = conf.BootstrapNode(); err != nil {
+				return nil, fmt.Errorf("static relays: %w", err)
+			}
+		}
+		if len(relays) == 0 {
+			return nil, fmt.Errorf("relay client requires static relays or bootstrap nodes")
+		}
+		options = append(options, libp2p.EnableRelay(), libp2p.EnableAutoRelayWithStaticRelays(relays))
+	}
+	if len(nat.Announce) > 0 {
+		announce := make([]multiaddr.Multiaddr, 0, len(nat.Announce))
+		for _, addr := range nat.Announce {
+			maddr, err := multiaddr.NewMultiaddr(addr)
+			if err != nil {
+				return nil, fmt.Errorf("parse announce address %q: %w", addr, err)
+			}
+			announce = append(announce, maddr)
+		}
+		options = append(options, libp2p.AddrsFactory(func([]multiaddr.Multiaddr) []multiaddr.Multiaddr {
+			return announce
+		}))
+	}
+	switch strings.ToLower(nat.Reachability) {
+	case "":
+	case ReachabilityPublic:
+		options = append(options, libp2p.ForceReachabilityPublic())
+	case ReachabilityPrivate:
+		options = append(options, libp2p.ForceReachabilityPrivate())
+	default:
+		return nil, fmt.Errorf("unknown reachability %q", nat.Reachability)
+	}
+	return options, nil
+}
+
+// watchReachability
+// 记录 AutoNAT 探测到的可达性, 变化时输出日志
+func (pubSub *PubSub) watchReachability() error {
+	sub, err := pubSub.host.EventBus().Subscribe(new(event.EvtLocalReachabilityChanged))
+	if err != nil {
+		return fmt.Errorf("subscribe reachability: %w", err)
+	}
+	go func() {
+		defer sub.Close()
+		for {
+			select {
+			case <-pubSub.ctx.Done():
+				return
+			case e, ok := <-sub.Out():
+				if !ok {
+					return
+				}
+				reachability := e.(event.EvtLocalReachabilityChanged).Reachability
+				pubSub.reachability.Store(int32(reachability))
+				pubSub.logger.Info("reachability changed", "reachability", reachability, "addr", pubSub.ReachableAddr())
+			}
+		}
+	}()
+	return nil
+}
+
+func (pubSub *PubSub) Reachability() network.Reachability {
+	return network.Reachability(pubSub.reachability.Load())
+}
+
+// ReachableAddr
+// 只返回外部可以访问的地址: 公网 IP、DNS 以及经过公网中继的地址, 格式与 Addr 相同
+func (pubSub *PubSub) ReachableAddr() []string {
+	var addrs []string
+	for _, addr := range pubSub.host.Addrs() {
+		if manet.IsPublicAddr(addr) {
+			addrs = append(addrs, fmt.Sprintf(`%s/p2p/%s`, addr, pubSub.host.ID()))
+		}
+	}
+	return addrs
+}
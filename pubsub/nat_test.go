@@ -0,0 +1,79 @@
+package pubsub
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/yydsqu/tools/log"
+)
+
+func TestNATOptions(t *testing.T) {
+	if _, err := (&Config{NAT: NATConfig{RelayClient: true}}).natOptions(); err == nil {
+		t.Fatal("expected relay client without relays to fail")
+	}
+	if _, err := (&Config{NAT: NATConfig{Announce: []string{"not an address"}}}).natOptions(); err == nil {
+		t.Fatal("expected invalid announce address to fail")
+	}
+	if _, err := (&Config{NAT: NATConfig{Reachability: "unknown"}}).natOptions(); err == nil {
+		t.Fatal("expected unknown reachability to fail")
+	}
+}
+
+func TestAnnounce(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dir := t.TempDir()
+	node, err := NewPubSub(ctx, log.Root(), &Config{
+		Identity: filepath.Join(dir, "identity"),
+		Listen:   []string{"/ip4/127.0.0.1/tcp/0"},
+		NAT: NATConfig{
+			AutoNAT:      true,
+			HolePunching: true,
+			RelayService: true,
+			Announce:     []string{"/ip4/127.0.0.1/tcp/4001", "/ip4/1.2.3.4/tcp/4001", "/dns4/node.example.com/tcp/4001"},
+			Reachability: ReachabilityPublic,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.Close()
+	addr := node.Addr()
+	if len(addr) != 3 || !slices.Contains(addr, "/ip4/127.0.0.1/tcp/4001/p2p/"+node.Self().String()) {
+		t.Fatalf("unexpected addr %v", addr)
+	}
+	reachable := node.ReachableAddr()
+	if len(reachable) != 2 || slices.ContainsFunc(reachable, func(s string) bool { return strings.HasPrefix(s, "/ip4/127.0.0.1") }) {
+		t.Fatalf("unexpected reachable addr %v", reachable)
+	}
+}
+
+func TestRelayClient(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	relay := newTestNodes(t, ctx, 1, func(conf *Config) {
+		conf.NAT = NATConfig{RelayService: true, Reachability: ReachabilityPublic}
+	})[0]
+	dir := t.TempDir()
+	node, err := NewPubSub(ctx, log.Root(), &Config{
+		Identity:  filepath.Join(dir, "identity"),
+		Listen:    []string{"/ip4/127.0.0.1/tcp/0"},
+		Bootstrap: relay.Addr(),
+		NAT:       NATConfig{RelayClient: true, HolePunching: true, Reachability: ReachabilityPrivate},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.Close()
+	waitFor(t, 5*time.Second, func() bool {
+		return node.Reachability() == network.ReachabilityPrivate
+	})
+	if len(node.ReachableAddr()) != 0 {
+		t.Fatalf("unexpected reachable addr %v", node.ReachableAddr())
+	}
+}
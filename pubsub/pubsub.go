@@ -1,9 +1,15 @@
 package pubsub
 
 import (
+	"bytes"
 	"cmp"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
 	"github.com/libp2p/go-libp2p"
 	dht "github.com/libp2p/go-libp2p-kad-dht"
 	pubsub "github.com/libp2p/go-libp2p-pubsub"
@@ -12,12 +18,20 @@ import (
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/pnet"
+	"github.com/libp2p/go-libp2p/p2p/discovery/mdns"
 	"github.com/libp2p/go-libp2p/p2p/discovery/routing"
 	"github.com/libp2p/go-libp2p/p2p/discovery/util"
+	"github.com/libp2p/go-libp2p/p2p/transport/tcp"
+	"github.com/libp2p/go-libp2p/p2p/transport/websocket"
 	"github.com/multiformats/go-multiaddr"
 	"github.com/yydsqu/tools/log"
+	"github.com/yydsqu/tools/utils"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -34,9 +48,25 @@ var (
 )
 
 type Config struct {
-	Identity  string   `json:"identity" toml:"identity" yaml:"identity"`
-	Listen    []string `json:"listen" toml:"listen" yaml:"listen"`
-	Bootstrap []string `json:"bootstrap" toml:"bootstrap" yaml:"bootstrap"`
+	Identity string `json:"identity" toml:"identity" yaml:"identity"`
+	// PSK 私有网络密钥文件, 设置后只使用 tcp 和 websocket 传输
+	PSK string `json:"psk" toml:"psk" yaml:"psk"`
+	// DataDir 持久化 peerstore 和 dht 的 leveldb 目录, 为空时只保存在内存中
+	DataDir       string          `json:"data_dir" toml:"data_dir" yaml:"data_dir"`
+	Gater         GaterConfig     `json:"gater" toml:"gater" yaml:"gater"`
+	Listen        []string        `json:"listen" toml:"listen" yaml:"listen"`
+	Bootstrap     []string        `json:"bootstrap" toml:"bootstrap" yaml:"bootstrap"`
+	Router        string          `json:"router" toml:"router" yaml:"router"`
+	GossipSub     GossipSubConfig `json:"gossipsub" toml:"gossipsub" yaml:"gossipsub"`
+	RandomSubSize int             `json:"randomsub_size" toml:"randomsub_size" yaml:"randomsub_size"`
+	Discovery     DiscoveryConfig `json:"discovery" toml:"discovery" yaml:"discovery"`
+	NAT           NATConfig       `json:"nat" toml:"nat" yaml:"nat"`
+	// SignaturePolicy 默认 strict_sign, 不签名时无法校验 Publishers
+	SignaturePolicy string                 `json:"signature_policy" toml:"signature_policy" yaml:"signature_policy"`
+	Publishers      []string               `json:"publishers" toml:"publishers" yaml:"publishers"`
+	Blacklist       []string               `json:"blacklist" toml:"blacklist" yaml:"blacklist"`
+	RejectLimit     int                    `json:"reject_limit" toml:"reject_limit" yaml:"reject_limit"`
+	Topics          map[string]TopicConfig `json:"topics" toml:"topics" yaml:"topics"`
 }
 
 func (conf *Config) ListenAddr() []string {
@@ -46,6 +76,21 @@ func (conf *Config) ListenAddr() []string {
 	return conf.Listen
 }
 
+// listenAddr
+// quic、webtransport 和 webrtc 不支持私有网络, 设置 PSK 时忽略 udp 地址
+func (conf *Config) listenAddr() []string {
+	if conf.PSK == "" {
+		return conf.ListenAddr()
+	}
+	var addrs []string
+	for _, addr := range conf.ListenAddr() {
+		if !strings.Contains(addr, "/udp/") {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
 func (conf *Config) BootstrapNode() ([]peer.AddrInfo, error) {
 	var (
 		addrInfo []peer.AddrInfo
@@ -80,6 +125,28 @@ func LoadOrGenerateKey(path string) (crypto.PrivKey, error) {
 	return crypto.UnmarshalPrivateKey(raw)
 }
 
+// LoadOrGeneratePSK
+// 文件格式与 ipfs swarm.key 相同, 网络内所有节点需要使用同一个文件
+func LoadOrGeneratePSK(path string) (pnet.PSK, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		key := make([]byte, 32)
+		if _, err = rand.Read(key); err != nil {
+			return nil, err
+		}
+		raw := "/key/swarm/psk/1.0.0/\n/base16/\n" + hex.EncodeToString(key) + "\n"
+		os.MkdirAll(filepath.Dir(path), 0700)
+		if err = os.WriteFile(path, []byte(raw), 0600); err != nil {
+			return nil, fmt.Errorf("write file %s: %w", path, err)
+		}
+		return key, nil
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read file %s: %w", path, err)
+	}
+	return pnet.DecodeV1PSK(bytes.NewReader(raw))
+}
+
 type PubSub struct {
 	ctx       context.Context
 	conf      *Config
@@ -87,9 +154,24 @@ type PubSub struct {
 	identity  crypto.PrivKey
 	bootstrap []peer.AddrInfo
 	discovery *routing.RoutingDiscovery
+	discover  DiscoveryConfig
+	static    []peer.AddrInfo
+	mdns      mdns.Service
 	host      host.Host
 	pubSub    *pubsub.PubSub
 	dht       *dht.IpfsDHT
+	store     datastore.Batching
+	tracer    *tracer
+	// AutoNAT 探测到的可达性
+	reachability atomic.Int32
+	// 消息校验
+	gater       *Gater
+	publishers  map[peer.ID]struct{}
+	blacklist   *blacklist
+	guardMutex  sync.Mutex
+	guards      map[string]*guard
+	rejects     map[peer.ID]*rejectCount
+	rejectSwept time.Time
 }
 
 func (pubSub *PubSub) Listen(n network.Network, multiaddr multiaddr.Multiaddr) {
@@ -100,8 +182,12 @@ func (pubSub *PubSub) ListenClose(n network.Network, multiaddr multiaddr.Multiad
 	pubSub.logger.Debug("closing incoming connections", "addr", multiaddr.String())
 }
 
+// Connected
+// 只记录主动连接的地址, 入站连接的远端端口是临时端口, 持久化后重启也连不上
 func (pubSub *PubSub) Connected(n network.Network, conn network.Conn) {
-	pubSub.host.Peerstore().AddAddrs(conn.RemotePeer(), []multiaddr.Multiaddr{conn.RemoteMultiaddr()}, peerstore.PermanentAddrTTL)
+	if conn.Stat().Direction == network.DirOutbound {
+		pubSub.host.Peerstore().AddAddrs(conn.RemotePeer(), []multiaddr.Multiaddr{conn.RemoteMultiaddr()}, knownAddrTTL)
+	}
 	pubSub.logger.Debug("connected", "peer", conn.RemotePeer(), "count", len(n.Peers()))
 }
 
@@ -117,6 +203,10 @@ func (pubSub *PubSub) PubSub() *pubsub.PubSub {
 	return pubSub.pubSub
 }
 
+func (pubSub *PubSub) Gater() *Gater {
+	return pubSub.gater
+}
+
 func (pubSub *PubSub) Self() peer.ID {
 	return pubSub.host.ID()
 }
@@ -130,6 +220,9 @@ func (pubSub *PubSub) Addr() []string {
 }
 
 func (pubSub *PubSub) Topic(topic string, opts ...pubsub.TopicOpt) (*pubsub.Topic, error) {
+	if _, err := pubSub.topicGuard(topic); err != nil {
+		return nil, err
+	}
 	return pubSub.pubSub.Join(topic, opts...)
 }
 
@@ -159,9 +252,9 @@ func (pubSub *PubSub) discoverPeers() {
 	if len(pubSub.host.Network().Peers()) == 0 {
 		pubSub.ConnectBootstrap()
 	}
-	util.Advertise(ctx, pubSub.discovery, NS)
+	util.Advertise(ctx, pubSub.discovery, pubSub.discover.Namespace)
 	// 寻找节点
-	peerChan, err := pubSub.discovery.FindPeers(ctx, NS)
+	peerChan, err := pubSub.discovery.FindPeers(ctx, pubSub.discover.Namespace)
 	if err != nil {
 		pubSub.logger.Warn("find peers failure", "err", err)
 		return
@@ -183,13 +276,26 @@ func (pubSub *PubSub) discoverPeers() {
 	}
 }
 
+// Start
+// 启动后立即执行一次发现, 之后按 DiscoveryConfig 的间隔和退避执行
 func (pubSub *PubSub) Start() error {
 	var (
-		ticker = time.NewTicker(time.Second * 30)
-		err    error
+		conf    = pubSub.discover
+		static  = time.NewTicker(time.Duration(conf.Interval))
+		timer   = time.NewTimer(0)
+		backoff = utils.NewBackoff(time.Duration(conf.Interval), time.Duration(conf.MaxInterval))
+		err     error
 	)
-	defer ticker.Stop()
+	defer static.Stop()
+	defer timer.Stop()
 	pubSub.ConnectBootstrap()
+	go pubSub.connectStatic()
+	go pubSub.reconnect()
+	if pubSub.mdns != nil {
+		if err = pubSub.mdns.Start(); err != nil {
+			return fmt.Errorf("start mdns failure: %w", err)
+		}
+	}
 	if err = pubSub.dht.Bootstrap(pubSub.ctx); err != nil {
 		return err
 	}
@@ -197,20 +303,47 @@ func (pubSub *PubSub) Start() error {
 		select {
 		case <-pubSub.ctx.Done():
 			return nil
-		case <-ticker.C:
+		case <-static.C:
+			go pubSub.connectStatic()
+		case <-timer.C:
 			go pubSub.discoverPeers()
+			if len(pubSub.host.Network().Peers()) >= conf.MinPeers {
+				timer.Reset(backoff.Next())
+			} else {
+				backoff.Reset()
+				timer.Reset(time.Duration(conf.Interval))
+			}
 		}
 	}
 }
 
+// Close
+// 关闭 dht、libp2p host 和持久化存储, 之后 PubSub 不可再用
+func (pubSub *PubSub) Close() error {
+	var err error
+	collector.nodes.Delete(pubSub.Self())
+	if pubSub.mdns != nil {
+		err = pubSub.mdns.Close()
+	}
+	err = errors.Join(err, pubSub.dht.Close(), pubSub.host.Close())
+	if pubSub.store != nil {
+		err = errors.Join(err, pubSub.store.Close())
+	}
+	return err
+}
+
 func NewPubSub(ctx context.Context, logger log.Logger, conf *Config) (*PubSub, error) {
 	var (
 		pub = &PubSub{
-			ctx:    ctx,
-			conf:   conf,
-			logger: logger,
+			ctx:     ctx,
+			conf:    conf,
+			logger:  logger,
+			guards:  make(map[string]*guard),
+			rejects: make(map[peer.ID]*rejectCount),
+			tracer:  &tracer{mesh: make(map[string]map[peer.ID]struct{})},
 		}
-		err error
+		policy pubsub.MessageSignaturePolicy
+		err    error
 	)
 	if pub.identity, err = LoadOrGenerateKey(cmp.Or(conf.Identity, "identity")); err != nil {
 		return nil, fmt.Errorf("load key failure: %w", err)
@@ -218,16 +351,96 @@ func NewPubSub(ctx context.Context, logger log.Logger, conf *Config) (*PubSub, e
 	if pub.bootstrap, err = conf.BootstrapNode(); err != nil {
 		return nil, fmt.Errorf("bootstrap node failure: %w", err)
 	}
-	if pub.host, err = libp2p.New(libp2p.Identity(pub.identity), libp2p.ListenAddrStrings(conf.ListenAddr()...), libp2p.DefaultTransports); err != nil {
+	pub.discover = conf.Discovery.withDefaults()
+	if pub.static, err = parseAddrInfos(conf.Discovery.StaticPeers); err != nil {
+		return nil, fmt.Errorf("static peers failure: %w", err)
+	}
+	if policy, err = conf.signaturePolicy(); err != nil {
+		return nil, err
+	}
+	if pub.publishers, err = decodePeers(conf.Publishers); err != nil {
+		return nil, fmt.Errorf("publishers: %w", err)
+	}
+	pub.blacklist = &blacklist{peers: make(map[peer.ID]struct{})}
+	for _, s := range conf.Blacklist {
+		id, err := peer.Decode(s)
+		if err != nil {
+			return nil, fmt.Errorf("blacklist: decode peer id %q: %w", s, err)
+		}
+		pub.blacklist.Add(id)
+	}
+	if pub.gater, err = NewGater(conf.Gater); err != nil {
+		return nil, fmt.Errorf("gater failure: %w", err)
+	}
+	options := []libp2p.Option{
+		libp2p.Identity(pub.identity),
+		libp2p.ListenAddrStrings(conf.listenAddr()...),
+		libp2p.ConnectionGater(pub.gater),
+	}
+	if conf.PSK != "" {
+		var psk pnet.PSK
+		if psk, err = LoadOrGeneratePSK(conf.PSK); err != nil {
+			return nil, fmt.Errorf("load psk failure: %w", err)
+		}
+		options = append(options, libp2p.PrivateNetwork(psk), libp2p.Transport(tcp.NewTCPTransport), libp2p.Transport(websocket.New))
+	} else {
+		options = append(options, libp2p.DefaultTransports)
+	}
+	natOptions, err := conf.natOptions()
+	if err != nil {
+		return nil, fmt.Errorf("nat options failure: %w", err)
+	}
+	options = append(options, natOptions...)
+	if pub.store, err = conf.openStore(); err != nil {
+		return nil, err
+	}
+	dhtOptions := []dht.Option{dht.Mode(dht.ModeServer), dht.ProtocolPrefix(ProtocolPrefix)}
+	if pub.store != nil {
+		var ps peerstore.Peerstore
+		if ps, err = newPeerstore(ctx, pub.store); err != nil {
+			pub.store.Close()
+			return nil, fmt.Errorf("create peerstore failure: %w", err)
+		}
+		options = append(options, libp2p.Peerstore(ps))
+		dhtOptions = append(dhtOptions, dht.Datastore(namespace.Wrap(pub.store, dhtKey)))
+	}
+	if pub.host, err = libp2p.New(options...); err != nil {
+		if pub.store != nil {
+			pub.store.Close()
+		}
 		return nil, fmt.Errorf("create p2p host failure: %w", err)
 	}
-	if pub.dht, err = dht.New(ctx, pub.host, dht.Mode(dht.ModeServer), dht.ProtocolPrefix(ProtocolPrefix)); err != nil {
+	pub.tracer.self = pub.host.ID()
+	if pub.dht, err = dht.New(ctx, pub.host, dhtOptions...); err != nil {
+		pub.host.Close()
+		if pub.store != nil {
+			pub.store.Close()
+		}
 		return nil, fmt.Errorf("create dht failure: %w", err)
 	}
+	if err = pub.watchReachability(); err != nil {
+		pub.dht.Close()
+		pub.host.Close()
+		if pub.store != nil {
+			pub.store.Close()
+		}
+		return nil, err
+	}
 	pub.discovery = routing.NewRoutingDiscovery(pub.dht)
-	if pub.pubSub, err = pubsub.NewFloodSub(ctx, pub.host, pubsub.WithDiscovery(pub.discovery)); err != nil {
+	pub.protectStatic()
+	if pub.discover.MDNS {
+		pub.mdns = mdns.NewMdnsService(pub.host, pub.discover.Namespace, pub)
+	}
+	if pub.pubSub, err = conf.newRouter(ctx, pub.host,
+		pubsub.WithDiscovery(pub.discovery),
+		pubsub.WithMessageSignaturePolicy(policy),
+		pubsub.WithBlacklist(pub.blacklist),
+		pubsub.WithRawTracer(pub.tracer),
+	); err != nil {
+		pub.Close()
 		return nil, fmt.Errorf("create pubsub failure: %w", err)
 	}
 	pub.host.Network().Notify(pub)
+	collector.nodes.Store(pub.Self(), pub)
 	return pub, err
 }
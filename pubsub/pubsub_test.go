@@ -0,0 +1,52 @@
+package pubsub
+
+import (
+	"context"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/yydsqu/tools/log"
+)
+
+// newTestNodes
+// 创建监听本地随机端口的节点, 全部连接到第一个节点
+func newTestNodes(t *testing.T, ctx context.Context, n int, opts ...func(conf *Config)) []*PubSub {
+	t.Helper()
+	dir := t.TempDir()
+	nodes := make([]*PubSub, 0, n)
+	for i := 0; i < n; i++ {
+		conf := &Config{
+			Identity: filepath.Join(dir, "identity_"+strconv.Itoa(i)),
+			Listen:   []string{"/ip4/127.0.0.1/tcp/0"},
+		}
+		for _, opt := range opts {
+			opt(conf)
+		}
+		node, err := NewPubSub(ctx, log.Root(), conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { node.Close() })
+		nodes = append(nodes, node)
+	}
+	for _, node := range nodes[1:] {
+		if err := node.Connect(ctx, peer.AddrInfo{ID: nodes[0].Self(), Addrs: nodes[0].Host().Addrs()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return nodes
+}
+
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,602 @@
+package pubsub
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/yydsqu/tools/log"
+	"maps"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	ReliableTopicPrefix = "reliable/"
+	maxFetchAttempts    = 3
+)
+
+var (
+	ErrInvalidRecord = errors.New("pubsub: invalid reliable record")
+)
+
+type ReliableConfig struct {
+	History  int      `json:"history" toml:"history" yaml:"history"`
+	Interval Duration `json:"interval" toml:"interval" yaml:"interval"`
+	CatchUp  bool     `json:"catch_up" toml:"catch_up" yaml:"catch_up"`
+	Buffer   int      `json:"buffer" toml:"buffer" yaml:"buffer"`
+}
+
+// ReliableMessage
+// Epoch 是发布者本次运行的开始时间, 发布者重启后 Epoch 变大, Seq 重新从 1 开始
+type ReliableMessage struct {
+	Publisher peer.ID
+	Epoch     uint64
+	Seq       uint64
+	Data      []byte
+}
+
+// record
+// peer ID 不是合法的 utf-8, 编码为 bytes. Sig 是发布者的签名, 转发和补拉时都可以验证
+type record struct {
+	Publisher []byte `cbor:"p"`
+	Epoch     uint64 `cbor:"e"`
+	Seq       uint64 `cbor:"s"`
+	Data      []byte `cbor:"d"`
+	Sig       []byte `cbor:"g"`
+}
+
+type head struct {
+	Publisher []byte `cbor:"p"`
+	Epoch     uint64 `cbor:"e"`
+	Seq       uint64 `cbor:"s"`
+}
+
+// envelope
+// 数据消息携带 Record, 周期性的确认消息携带每个发布者已连续收到的最大序号
+type envelope struct {
+	Record *record `cbor:"r,omitempty"`
+	Heads  []head  `cbor:"h,omitempty"`
+}
+
+type rangeRequest struct {
+	Publisher []byte `cbor:"p"`
+	Epoch     uint64 `cbor:"e"`
+	From      uint64 `cbor:"f"`
+	To        uint64 `cbor:"t"`
+}
+
+type fetchResult struct {
+	source  peer.ID
+	req     rangeRequest
+	records []record
+	err     error
+}
+
+type inbound struct {
+	from peer.ID
+	env  envelope
+}
+
+// ReliableTopic
+// 在 topic 之上按发布者序号保证顺序和不丢失: 收到的消息保存在有界历史中,
+// 发现缺口或确认消息中的序号领先时, 通过 RPC 向发布者或确认者补拉缺失的消息.
+// 每条消息带有发布者的签名, 补拉的消息同样验证签名和 Publishers, 本节点发布的消息不会投递给自己
+type ReliableTopic struct {
+	ctx      context.Context
+	pubSub   *PubSub
+	name     string
+	conf     ReliableConfig
+	logger   log.Logger
+	topic    *pubsub.Topic
+	guard    *guard
+	client   *Client[rangeRequest, record]
+	messages chan ReliableMessage
+	inbox    chan inbound
+	fetched  chan fetchResult
+	mutex    sync.Mutex
+	epoch    uint64
+	seq      uint64
+	history  map[peer.ID][]record
+	acks     map[peer.ID]uint64
+	// 以下字段只在 Start 的循环中修改
+	epochs   map[peer.ID]uint64
+	next     map[peer.ID]uint64
+	pending  map[peer.ID]map[uint64]record
+	inflight map[peer.ID]bool
+	attempts map[peer.ID]int
+}
+
+func (r *ReliableTopic) Name() string {
+	return r.name
+}
+
+// Messages
+// 按发布者序号有序投递, Start 返回后关闭
+func (r *ReliableTopic) Messages() <-chan ReliableMessage {
+	return r.messages
+}
+
+// Acks
+// 其他节点确认收到的本节点发布的最大连续序号
+func (r *ReliableTopic) Acks() map[peer.ID]uint64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return maps.Clone(r.acks)
+}
+
+// Publish
+// 先写入历史再广播, 广播失败时其他节点仍可以通过确认消息补拉
+func (r *ReliableTopic) Publish(ctx context.Context, data []byte) (uint64, error) {
+	r.mutex.Lock()
+	rec, err := r.sign(data)
+	if err != nil {
+		r.mutex.Unlock()
+		return 0, err
+	}
+	r.remember(r.pubSub.Self(), rec)
+	r.mutex.Unlock()
+	return rec.Seq, r.send(ctx, envelope{Record: &rec})
+}
+
+// sign
+// 持有 mutex 时调用, 分配下一个序号并签名
+func (r *ReliableTopic) sign(data []byte) (record, error) {
+	rec := record{Publisher: []byte(r.pubSub.Self()), Epoch: r.epoch, Seq: r.seq + 1, Data: data}
+	sig, err := r.pubSub.identity.Sign(r.signed(rec))
+	if err != nil {
+		return rec, fmt.Errorf("sign reliable record: %w", err)
+	}
+	r.seq++
+	rec.Sig = sig
+	return rec, nil
+}
+
+// signed
+// 签名覆盖 topic、发布者、epoch、序号和数据
+func (r *ReliableTopic) signed(rec record) []byte {
+	data := make([]byte, 0, len(ReliableTopicPrefix)+len(r.name)+len(rec.Publisher)+len(rec.Data)+32)
+	data = append(data, ReliableTopicPrefix+r.name...)
+	data = binary.AppendUvarint(data, uint64(len(rec.Publisher)))
+	data = append(data, rec.Publisher...)
+	data = binary.BigEndian.AppendUint64(data, rec.Epoch)
+	data = binary.BigEndian.AppendUint64(data, rec.Seq)
+	return append(data, rec.Data...)
+}
+
+// authorized
+// 可靠 topic 的确认消息由所有节点发布, Publishers 只用来限制数据消息的发布者, topic 单独配置时优先
+func (r *ReliableTopic) authorized(publisher peer.ID) bool {
+	publishers := r.guard.publishers
+	if publishers == nil {
+		publishers = r.pubSub.publishers
+	}
+	if publishers == nil {
+		return true
+	}
+	_, ok := publishers[publisher]
+	return ok
+}
+
+func (r *ReliableTopic) verify(rec record) error {
+	publisher := peer.ID(rec.Publisher)
+	if !r.authorized(publisher) {
+		return fmt.Errorf("%w: %s", ErrUnauthorizedPublisher, publisher)
+	}
+	key := r.pubSub.host.Peerstore().PubKey(publisher)
+	if key == nil {
+		return fmt.Errorf("%w: unknown public key of %s", ErrInvalidRecord, publisher)
+	}
+	if ok, err := key.Verify(r.signed(rec), rec.Sig); err != nil || !ok {
+		return fmt.Errorf("%w: bad signature from %s", ErrInvalidRecord, publisher)
+	}
+	return nil
+}
+
+// validate
+// 广播的数据消息必须由发布者本人发出, 防止冒用其他发布者的序号; 不签名的策略下没有 from, 只验证记录的签名
+func (r *ReliableTopic) validate(ctx context.Context, msg *pubsub.Message) error {
+	var env envelope
+	if err := CBOR.Unmarshal(msg.Data, &env); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidRecord, err)
+	}
+	if env.Record == nil {
+		return nil
+	}
+	if from := msg.GetFrom(); from != "" && peer.ID(env.Record.Publisher) != from {
+		return fmt.Errorf("%w: publisher %s sent by %s", ErrInvalidRecord, peer.ID(env.Record.Publisher), msg.GetFrom())
+	}
+	return r.verify(*env.Record)
+}
+
+func (r *ReliableTopic) send(ctx context.Context, env envelope) error {
+	data, err := CBOR.Marshal(env)
+	if err != nil {
+		return err
+	}
+	return r.topic.Publish(ctx, data)
+}
+
+// remember
+// 持有 mutex 时调用
+func (r *ReliableTopic) remember(publisher peer.ID, rec record) {
+	history := append(r.history[publisher], rec)
+	if len(history) > r.conf.History {
+		history = slices.Delete(history, 0, len(history)-r.conf.History)
+	}
+	r.history[publisher] = history
+}
+
+// lookup
+// 同一发布者的历史只保存一个 epoch
+func (r *ReliableTopic) lookup(publisher peer.ID, epoch, from, to uint64) []record {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	history := r.history[publisher]
+	if len(history) == 0 || history[0].Epoch != epoch {
+		return nil
+	}
+	i := sort.Search(len(history), func(i int) bool { return history[i].Seq >= from })
+	j := sort.Search(len(history), func(i int) bool { return history[i].Seq > to })
+	return slices.Clone(history[i:max(i, j)])
+}
+
+func (r *ReliableTopic) heads() []head {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	heads := make([]head, 0, len(r.epochs)+1)
+	heads = append(heads, head{Publisher: []byte(r.pubSub.Self()), Epoch: r.epoch, Seq: r.seq})
+	for publisher, epoch := range r.epochs {
+		heads = append(heads, head{Publisher: []byte(publisher), Epoch: epoch, Seq: r.next[publisher] - 1})
+	}
+	return heads
+}
+
+// Start
+// 阻塞直到 ctx 结束
+func (r *ReliableTopic) Start() error {
+	sub, err := r.topic.Subscribe()
+	if err != nil {
+		return err
+	}
+	defer sub.Cancel()
+	defer close(r.messages)
+	go r.receive(sub)
+
+	ticker := time.NewTicker(time.Duration(r.conf.Interval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.ctx.Done():
+			return nil
+		case in := <-r.inbox:
+			if rec := in.env.Record; rec != nil {
+				publisher := peer.ID(rec.Publisher)
+				r.accept(publisher, *rec)
+				r.request(publisher, publisher, rec.Epoch, 0)
+			}
+			for _, h := range in.env.Heads {
+				r.observe(in.from, peer.ID(h.Publisher), h.Epoch, h.Seq)
+			}
+		case res := <-r.fetched:
+			r.complete(res)
+		case <-ticker.C:
+			if err = r.send(r.ctx, envelope{Heads: r.heads()}); err != nil && r.ctx.Err() == nil {
+				r.logger.Trace("publish reliable heads failure", "topic", r.name, "err", err)
+			}
+		}
+	}
+}
+
+func (r *ReliableTopic) receive(sub *pubsub.Subscription) {
+	self := r.pubSub.Self()
+	for {
+		msg, err := sub.Next(r.ctx)
+		if err != nil {
+			return
+		}
+		if msg.GetFrom() == self {
+			continue
+		}
+		var env envelope
+		if err = CBOR.Unmarshal(msg.Data, &env); err != nil {
+			r.logger.Trace("invalid reliable message", "topic", r.name, "peer", msg.GetFrom(), "err", err)
+			continue
+		}
+		select {
+		case r.inbox <- inbound{from: msg.GetFrom(), env: env}:
+		case <-r.ctx.Done():
+			return
+		}
+	}
+}
+
+// expect
+// 第一次见到发布者时决定从哪里开始投递
+func (r *ReliableTopic) expect(publisher peer.ID, seq uint64) uint64 {
+	if next, ok := r.next[publisher]; ok {
+		return next
+	}
+	next := seq
+	if r.conf.CatchUp {
+		next = 1
+	}
+	r.mutex.Lock()
+	r.next[publisher] = next
+	r.mutex.Unlock()
+	return next
+}
+
+// current
+// 只在收到验证过的消息时调用. 发布者重启后 epoch 变大, 丢弃旧 epoch 的状态并从 1 开始接收,
+// 旧 epoch 的消息返回 false
+func (r *ReliableTopic) current(publisher peer.ID, epoch uint64) bool {
+	known, ok := r.epochs[publisher]
+	switch {
+	case ok && epoch == known:
+		return true
+	case ok && epoch < known:
+		return false
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.epochs[publisher] = epoch
+	if ok {
+		r.logger.Debug("reliable publisher restarted", "topic", r.name, "publisher", publisher, "epoch", epoch)
+		r.next[publisher] = 1
+		delete(r.history, publisher)
+		delete(r.pending, publisher)
+		r.attempts[publisher] = 0
+	}
+	return true
+}
+
+func (r *ReliableTopic) accept(publisher peer.ID, rec record) {
+	if publisher == r.pubSub.Self() || !r.current(publisher, rec.Epoch) || rec.Seq < r.expect(publisher, rec.Seq) {
+		return
+	}
+	if r.pending[publisher] == nil {
+		r.pending[publisher] = make(map[uint64]record)
+	}
+	r.pending[publisher][rec.Seq] = rec
+	r.drain(publisher)
+}
+
+// drain
+// 投递从 next 开始连续的消息
+func (r *ReliableTopic) drain(publisher peer.ID) {
+	pending := r.pending[publisher]
+	for {
+		next := r.next[publisher]
+		rec, ok := pending[next]
+		if !ok {
+			return
+		}
+		delete(pending, next)
+		r.mutex.Lock()
+		r.remember(publisher, rec)
+		r.next[publisher] = next + 1
+		r.mutex.Unlock()
+		r.attempts[publisher] = 0
+		select {
+		case r.messages <- ReliableMessage{Publisher: publisher, Epoch: rec.Epoch, Seq: rec.Seq, Data: rec.Data}:
+		case <-r.ctx.Done():
+			return
+		}
+	}
+}
+
+func (r *ReliableTopic) firstPending(publisher peer.ID) (uint64, bool) {
+	if len(r.pending[publisher]) == 0 {
+		return 0, false
+	}
+	return slices.Min(slices.Collect(maps.Keys(r.pending[publisher]))), true
+}
+
+// skip
+// 缺失的消息已经不在任何已知节点的历史中, 放弃并从 to 继续
+func (r *ReliableTopic) skip(publisher peer.ID, to uint64) {
+	next := r.next[publisher]
+	if to <= next {
+		return
+	}
+	r.logger.Warn("reliable messages lost", "topic", r.name, "publisher", publisher, "from", next, "to", to-1)
+	for seq := range r.pending[publisher] {
+		if seq < to {
+			delete(r.pending[publisher], seq)
+		}
+	}
+	r.mutex.Lock()
+	r.next[publisher] = to
+	r.mutex.Unlock()
+	r.attempts[publisher] = 0
+	r.drain(publisher)
+}
+
+// observe
+// 处理确认消息, 对方领先时向对方补拉, 对方确认本节点本次运行的消息时记录 ack.
+// 确认消息没有签名, 只有补拉到验证过的消息后才会切换到新的 epoch
+func (r *ReliableTopic) observe(from, publisher peer.ID, epoch, seq uint64) {
+	if publisher == r.pubSub.Self() {
+		if epoch == r.epoch {
+			r.mutex.Lock()
+			r.acks[from] = seq
+			r.mutex.Unlock()
+		}
+		return
+	}
+	if seq == 0 {
+		return
+	}
+	known, ok := r.epochs[publisher]
+	switch {
+	case ok && epoch < known:
+		return
+	case !ok && !r.conf.CatchUp:
+		r.expect(publisher, seq+1)
+		return
+	case !ok:
+		r.expect(publisher, seq)
+	}
+	r.request(publisher, from, epoch, seq)
+}
+
+// request
+// to 为 0 时补拉到最早的乱序消息之前, 每个发布者同时只有一个补拉请求. 新的 epoch 从 1 开始补拉
+func (r *ReliableTopic) request(publisher, source peer.ID, epoch, to uint64) {
+	next := r.next[publisher]
+	if known, ok := r.epochs[publisher]; ok && epoch != known {
+		next = 1
+	}
+	if to == 0 {
+		first, ok := r.firstPending(publisher)
+		if !ok {
+			return
+		}
+		to = first - 1
+	}
+	if to < next || r.inflight[publisher] {
+		return
+	}
+	to = min(to, next+uint64(r.conf.History)-1)
+	r.inflight[publisher] = true
+	req := rangeRequest{Publisher: []byte(publisher), Epoch: epoch, From: next, To: to}
+	go func() {
+		ctx, cancel := context.WithTimeout(r.ctx, time.Duration(r.conf.Interval))
+		defer cancel()
+		res := fetchResult{source: source, req: req}
+		for rec, err := range r.client.Stream(ctx, source, req) {
+			if err != nil {
+				res.err = err
+				break
+			}
+			res.records = append(res.records, rec)
+		}
+		select {
+		case r.fetched <- res:
+		case <-r.ctx.Done():
+		}
+	}()
+}
+
+func (r *ReliableTopic) complete(res fetchResult) {
+	publisher := peer.ID(res.req.Publisher)
+	r.inflight[publisher] = false
+	if res.err != nil {
+		r.logger.Trace("fetch reliable messages failure", "topic", r.name, "peer", res.source, "err", res.err)
+	}
+	records := res.records[:0]
+	for _, rec := range res.records {
+		if !bytes.Equal(rec.Publisher, res.req.Publisher) || rec.Epoch != res.req.Epoch {
+			res.err = fmt.Errorf("%w: unexpected record from %s", ErrInvalidRecord, res.source)
+			break
+		}
+		if err := r.verify(rec); err != nil {
+			res.err = err
+			break
+		}
+		records = append(records, rec)
+	}
+	if res.err != nil {
+		r.logger.Debug("invalid reliable messages", "topic", r.name, "peer", res.source, "err", res.err)
+	}
+	res.records = records
+	// 流是和发布者直接建立的, 发布者正常返回说明请求的 epoch 就是它当前的 epoch
+	if res.err == nil && res.source == publisher {
+		r.current(publisher, res.req.Epoch)
+	}
+	before := r.next[publisher]
+	for _, rec := range res.records {
+		r.accept(publisher, rec)
+	}
+	// 没有补拉到新 epoch 的消息或者 epoch 已经过期
+	if epoch, ok := r.epochs[publisher]; !ok || epoch != res.req.Epoch || r.next[publisher] > res.req.To {
+		return
+	}
+	switch {
+	case res.err == nil && res.source == publisher:
+		// 发布者自己也没有更早的消息
+		if len(res.records) > 0 {
+			r.skip(publisher, res.records[0].Seq)
+		} else {
+			r.skip(publisher, res.req.To+1)
+		}
+	case r.next[publisher] == before:
+		r.attempts[publisher]++
+		if first, ok := r.firstPending(publisher); ok && r.attempts[publisher] >= maxFetchAttempts {
+			r.skip(publisher, first)
+		}
+	}
+	if r.next[publisher] <= res.req.To {
+		r.request(publisher, res.source, res.req.Epoch, res.req.To)
+	}
+}
+
+func (r *ReliableTopic) Close() error {
+	r.pubSub.RemoveHandler(r.client.Protocol())
+	return r.topic.Close()
+}
+
+// NewReliableTopic
+// 同一 PubSub 上每个 name 同时只能有一个 ReliableTopic, epoch 取创建时间, 要求重启前后时钟不回退
+func NewReliableTopic(ctx context.Context, pubSub *PubSub, name string, conf ReliableConfig) (*ReliableTopic, error) {
+	conf.History = cmp.Or(conf.History, 1024)
+	conf.Interval = cmp.Or(conf.Interval, Duration(5*time.Second))
+	conf.Buffer = cmp.Or(conf.Buffer, 256)
+	topic, err := pubSub.Topic(ReliableTopicPrefix + name)
+	if err != nil {
+		return nil, err
+	}
+	g, err := pubSub.topicGuard(ReliableTopicPrefix + name)
+	if err != nil {
+		topic.Close()
+		return nil, err
+	}
+	id := RPCProtocol(ReliableTopicPrefix + name)
+	r := &ReliableTopic{
+		ctx:      ctx,
+		pubSub:   pubSub,
+		name:     name,
+		conf:     conf,
+		logger:   pubSub.logger,
+		topic:    topic,
+		guard:    g,
+		client:   NewClient[rangeRequest, record](pubSub, id, CBOR),
+		messages: make(chan ReliableMessage, conf.Buffer),
+		inbox:    make(chan inbound, conf.Buffer),
+		fetched:  make(chan fetchResult),
+		epoch:    uint64(time.Now().UnixNano()),
+		history:  make(map[peer.ID][]record),
+		acks:     make(map[peer.ID]uint64),
+		epochs:   make(map[peer.ID]uint64),
+		next:     make(map[peer.ID]uint64),
+		pending:  make(map[peer.ID]map[uint64]record),
+		inflight: make(map[peer.ID]bool),
+		attempts: make(map[peer.ID]int),
+	}
+	HandleStream(pubSub, id, CBOR, func(ctx context.Context, from peer.ID, req rangeRequest, send func(record) error) error {
+		if req.To < req.From {
+			return fmt.Errorf("invalid range [%d, %d]", req.From, req.To)
+		}
+		if peer.ID(req.Publisher) == pubSub.Self() && req.Epoch != r.epoch {
+			return fmt.Errorf("epoch %d is not current", req.Epoch)
+		}
+		for _, rec := range r.lookup(peer.ID(req.Publisher), req.Epoch, req.From, req.To) {
+			if err := send(rec); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	// 校验只依赖 topic 名称, 重新创建同名 ReliableTopic 时不重复注册
+	g.once.Do(func() {
+		g.add(r.validate)
+	})
+	return r, nil
+}
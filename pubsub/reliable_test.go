@@ -0,0 +1,183 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	pb "github.com/libp2p/go-libp2p-pubsub/pb"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func startReliable(t *testing.T, ctx context.Context, node *PubSub, conf ReliableConfig) *ReliableTopic {
+	t.Helper()
+	r, err := NewReliableTopic(ctx, node, "config", conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go r.Start()
+	return r
+}
+
+func receive(t *testing.T, r *ReliableTopic, n int) []ReliableMessage {
+	t.Helper()
+	var messages []ReliableMessage
+	for len(messages) < n {
+		select {
+		case msg := <-r.Messages():
+			messages = append(messages, msg)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received %d of %d messages", len(messages), n)
+		}
+	}
+	return messages
+}
+
+// lose
+// 只写入历史不广播, 模拟丢失的消息
+func lose(t *testing.T, r *ReliableTopic, data string) {
+	t.Helper()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	rec, err := r.sign([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.remember(r.pubSub.Self(), rec)
+}
+
+func TestReliableTopic(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nodes := newTestNodes(t, ctx, 3)
+	conf := ReliableConfig{Interval: Duration(100 * time.Millisecond), CatchUp: true}
+	pub := startReliable(t, ctx, nodes[0], conf)
+	sub := startReliable(t, ctx, nodes[1], conf)
+	waitFor(t, 5*time.Second, func() bool { return len(pub.topic.ListPeers()) > 0 })
+
+	for i := 1; i <= 3; i++ {
+		if _, err := pub.Publish(ctx, []byte(strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lose(t, pub, "4")
+	if _, err := pub.Publish(ctx, []byte("5")); err != nil {
+		t.Fatal(err)
+	}
+	lose(t, pub, "6")
+
+	for i, msg := range receive(t, sub, 6) {
+		if msg.Publisher != nodes[0].Self() || msg.Seq != uint64(i+1) || string(msg.Data) != strconv.Itoa(i+1) {
+			t.Fatalf("unexpected message %d: %+v", i, msg)
+		}
+	}
+	waitFor(t, 5*time.Second, func() bool {
+		return pub.Acks()[nodes[1].Self()] == 6
+	})
+
+	late := startReliable(t, ctx, nodes[2], conf)
+	for i, msg := range receive(t, late, 6) {
+		if msg.Seq != uint64(i+1) || string(msg.Data) != strconv.Itoa(i+1) {
+			t.Fatalf("unexpected catch-up message %d: %+v", i, msg)
+		}
+	}
+}
+
+func TestReliableSkip(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nodes := newTestNodes(t, ctx, 2)
+	conf := ReliableConfig{Interval: Duration(100 * time.Millisecond), History: 2, CatchUp: true}
+	pub := startReliable(t, ctx, nodes[0], conf)
+	for i := 1; i <= 5; i++ {
+		if _, err := pub.Publish(ctx, []byte(strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sub := startReliable(t, ctx, nodes[1], conf)
+	for i, msg := range receive(t, sub, 2) {
+		if msg.Seq != uint64(i+4) {
+			t.Fatalf("unexpected message %+v", msg)
+		}
+	}
+}
+
+func TestReliableRestart(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nodes := newTestNodes(t, ctx, 2)
+	conf := ReliableConfig{Interval: Duration(100 * time.Millisecond), CatchUp: true}
+	runCtx, stop := context.WithCancel(ctx)
+	pub := startReliable(t, runCtx, nodes[0], conf)
+	sub := startReliable(t, ctx, nodes[1], conf)
+	waitFor(t, 5*time.Second, func() bool { return len(pub.topic.ListPeers()) > 0 })
+	for i := 1; i <= 3; i++ {
+		if _, err := pub.Publish(ctx, []byte(strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	first := receive(t, sub, 3)
+
+	// 重启发布者, 序号重新从 1 开始
+	stop()
+	for range pub.Messages() {
+	}
+	if err := pub.Close(); err != nil {
+		t.Fatal(err)
+	}
+	pub = startReliable(t, ctx, nodes[0], conf)
+	waitFor(t, 5*time.Second, func() bool { return len(pub.topic.ListPeers()) > 0 })
+	lose(t, pub, "a")
+	if _, err := pub.Publish(ctx, []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	for i, msg := range receive(t, sub, 2) {
+		if msg.Epoch <= first[0].Epoch || msg.Seq != uint64(i+1) || string(msg.Data) != string(rune('a'+i)) {
+			t.Fatalf("unexpected message after restart %+v", msg)
+		}
+	}
+}
+
+func TestReliableForgedRecord(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nodes := newTestNodes(t, ctx, 3)
+	conf := ReliableConfig{Interval: Duration(100 * time.Millisecond)}
+	victim := startReliable(t, ctx, nodes[0], conf)
+	forger := startReliable(t, ctx, nodes[1], conf)
+
+	forger.mutex.Lock()
+	rec, err := forger.sign([]byte("forged"))
+	forger.mutex.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.Publisher = []byte(nodes[0].Self())
+	if err = forger.verify(rec); !errors.Is(err, ErrInvalidRecord) {
+		t.Fatalf("forged signature accepted: %v", err)
+	}
+	data, err := CBOR.Marshal(envelope{Record: &rec})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := &pubsub.Message{Message: &pb.Message{From: []byte(nodes[1].Self()), Data: data}}
+	if err = forger.validate(ctx, msg); !errors.Is(err, ErrInvalidRecord) {
+		t.Fatalf("record of another publisher accepted: %v", err)
+	}
+
+	// 发布者不在 Publishers 中时补拉的消息同样被拒绝
+	receiver := startReliable(t, ctx, nodes[2], conf)
+	nodes[2].publishers = map[peer.ID]struct{}{nodes[1].Self(): {}}
+	victim.mutex.Lock()
+	rec, err = victim.sign([]byte("unauthorized"))
+	victim.mutex.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = receiver.verify(rec); !errors.Is(err, ErrUnauthorizedPublisher) {
+		t.Fatalf("unauthorized record accepted: %v", err)
+	}
+}
@@ -0,0 +1,143 @@
+package pubsub
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"strings"
+	"time"
+)
+
+const (
+	RouterFloodSub  = "floodsub"
+	RouterGossipSub = "gossipsub"
+	RouterRandomSub = "randomsub"
+)
+
+// Duration
+// 配置中写成 "700ms"、"1m30s" 这样的字符串, 同时兼容纳秒整数
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Duration) UnmarshalText(data []byte) error {
+	v, err := time.ParseDuration(string(data))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(data, []byte(`"`)) {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		return d.UnmarshalText([]byte(s))
+	}
+	var v int64
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// GossipSubConfig
+// 零值字段使用 pubsub.DefaultGossipSubParams 中的默认值
+type GossipSubConfig struct {
+	D         int              `json:"d" toml:"d" yaml:"d"`
+	Dlo       int              `json:"dlo" toml:"dlo" yaml:"dlo"`
+	Dhi       int              `json:"dhi" toml:"dhi" yaml:"dhi"`
+	Dlazy     int              `json:"dlazy" toml:"dlazy" yaml:"dlazy"`
+	Heartbeat Duration         `json:"heartbeat" toml:"heartbeat" yaml:"heartbeat"`
+	FloodPub  *bool            `json:"flood_publish,omitempty" toml:"flood_publish,omitempty" yaml:"flood_publish,omitempty"`
+	Score     *PeerScoreConfig `json:"score,omitempty" toml:"score,omitempty" yaml:"score,omitempty"`
+}
+
+func (conf *GossipSubConfig) Params() pubsub.GossipSubParams {
+	params := pubsub.DefaultGossipSubParams()
+	params.D = cmp.Or(conf.D, params.D)
+	params.Dlo = cmp.Or(conf.Dlo, params.Dlo)
+	params.Dhi = cmp.Or(conf.Dhi, params.Dhi)
+	params.Dlazy = cmp.Or(conf.Dlazy, params.Dlazy)
+	params.HeartbeatInterval = cmp.Or(time.Duration(conf.Heartbeat), params.HeartbeatInterval)
+	return params
+}
+
+// PeerScoreConfig
+// 设置后开启 gossipsub 节点评分, 零值字段使用下面的默认值
+type PeerScoreConfig struct {
+	GossipThreshold             float64  `json:"gossip_threshold" toml:"gossip_threshold" yaml:"gossip_threshold"`
+	PublishThreshold            float64  `json:"publish_threshold" toml:"publish_threshold" yaml:"publish_threshold"`
+	GraylistThreshold           float64  `json:"graylist_threshold" toml:"graylist_threshold" yaml:"graylist_threshold"`
+	AcceptPXThreshold           float64  `json:"accept_px_threshold" toml:"accept_px_threshold" yaml:"accept_px_threshold"`
+	OpportunisticGraftThreshold float64  `json:"opportunistic_graft_threshold" toml:"opportunistic_graft_threshold" yaml:"opportunistic_graft_threshold"`
+	IPColocationFactorWeight    float64  `json:"ip_colocation_factor_weight" toml:"ip_colocation_factor_weight" yaml:"ip_colocation_factor_weight"`
+	IPColocationFactorThreshold int      `json:"ip_colocation_factor_threshold" toml:"ip_colocation_factor_threshold" yaml:"ip_colocation_factor_threshold"`
+	BehaviourPenaltyWeight      float64  `json:"behaviour_penalty_weight" toml:"behaviour_penalty_weight" yaml:"behaviour_penalty_weight"`
+	BehaviourPenaltyDecay       float64  `json:"behaviour_penalty_decay" toml:"behaviour_penalty_decay" yaml:"behaviour_penalty_decay"`
+	DecayInterval               Duration `json:"decay_interval" toml:"decay_interval" yaml:"decay_interval"`
+	DecayToZero                 float64  `json:"decay_to_zero" toml:"decay_to_zero" yaml:"decay_to_zero"`
+	RetainScore                 Duration `json:"retain_score" toml:"retain_score" yaml:"retain_score"`
+}
+
+func (conf *PeerScoreConfig) Thresholds() *pubsub.PeerScoreThresholds {
+	return &pubsub.PeerScoreThresholds{
+		GossipThreshold:             cmp.Or(conf.GossipThreshold, -500),
+		PublishThreshold:            cmp.Or(conf.PublishThreshold, -1000),
+		GraylistThreshold:           cmp.Or(conf.GraylistThreshold, -2500),
+		AcceptPXThreshold:           cmp.Or(conf.AcceptPXThreshold, 100),
+		OpportunisticGraftThreshold: cmp.Or(conf.OpportunisticGraftThreshold, 5),
+	}
+}
+
+func (conf *PeerScoreConfig) Params() *pubsub.PeerScoreParams {
+	return &pubsub.PeerScoreParams{
+		AppSpecificScore: func(peer.ID) float64 {
+			return 0
+		},
+		IPColocationFactorWeight:    conf.IPColocationFactorWeight,
+		IPColocationFactorThreshold: cmp.Or(conf.IPColocationFactorThreshold, 10),
+		BehaviourPenaltyWeight:      conf.BehaviourPenaltyWeight,
+		BehaviourPenaltyDecay:       cmp.Or(conf.BehaviourPenaltyDecay, 0.99),
+		DecayInterval:               cmp.Or(time.Duration(conf.DecayInterval), time.Second),
+		DecayToZero:                 cmp.Or(conf.DecayToZero, 0.01),
+		RetainScore:                 cmp.Or(time.Duration(conf.RetainScore), time.Hour),
+	}
+}
+
+// newRouter
+// 按 Config.Router 创建 pubsub, 默认 floodsub
+func (conf *Config) newRouter(ctx context.Context, h host.Host, opts ...pubsub.Option) (*pubsub.PubSub, error) {
+	switch strings.ToLower(conf.Router) {
+	case RouterFloodSub, "":
+		return pubsub.NewFloodSub(ctx, h, opts...)
+	case RouterGossipSub:
+		opts = append(opts, pubsub.WithGossipSubParams(conf.GossipSub.Params()))
+		if conf.GossipSub.FloodPub != nil {
+			opts = append(opts, pubsub.WithFloodPublish(*conf.GossipSub.FloodPub))
+		}
+		if score := conf.GossipSub.Score; score != nil {
+			opts = append(opts, pubsub.WithPeerScore(score.Params(), score.Thresholds()))
+		}
+		return pubsub.NewGossipSub(ctx, h, opts...)
+	case RouterRandomSub:
+		return pubsub.NewRandomSub(ctx, h, cmp.Or(conf.RandomSubSize, 50), opts...)
+	default:
+		return nil, fmt.Errorf("unknown router %q", conf.Router)
+	}
+}
@@ -0,0 +1,138 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/yydsqu/tools/log"
+)
+
+func TestGossipSubConfig(t *testing.T) {
+	var conf Config
+	err := json.Unmarshal([]byte(`{
+		"router": "gossipsub",
+		"gossipsub": {"d": 8, "dlo": 6, "dhi": 12, "heartbeat": 500000000, "score": {"graylist_threshold": -5000}}
+	}`), &conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := conf.GossipSub.Params()
+	if params.D != 8 || params.Dlo != 6 || params.Dhi != 12 || params.HeartbeatInterval != 500*time.Millisecond {
+		t.Fatalf("unexpected params %+v", params)
+	}
+	if params.Dlazy == 0 {
+		t.Fatal("unset field did not fall back to default")
+	}
+	thresholds := conf.GossipSub.Score.Thresholds()
+	if thresholds.GraylistThreshold != -5000 || thresholds.GossipThreshold != -500 {
+		t.Fatalf("unexpected thresholds %+v", thresholds)
+	}
+}
+
+func TestDurationConfig(t *testing.T) {
+	var conf GossipSubConfig
+	err := json.Unmarshal([]byte(`{"heartbeat": "700ms", "score": {"decay_interval": "2s", "retain_score": "1h30m"}}`), &conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Params().HeartbeatInterval != 700*time.Millisecond {
+		t.Fatalf("heartbeat %s", conf.Heartbeat)
+	}
+	params := conf.Score.Params()
+	if params.DecayInterval != 2*time.Second || params.RetainScore != 90*time.Minute {
+		t.Fatalf("decay %s retain %s", params.DecayInterval, params.RetainScore)
+	}
+	data, err := json.Marshal(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded GossipSubConfig
+	if err = json.Unmarshal(data, &decoded); err != nil || decoded.Heartbeat != conf.Heartbeat {
+		t.Fatalf("round trip %s: %v", data, err)
+	}
+	if err = json.Unmarshal([]byte(`{"heartbeat": "soon"}`), &conf); err == nil {
+		t.Fatal("expected invalid duration error")
+	}
+}
+
+func TestConfigDurations(t *testing.T) {
+	var conf Config
+	err := json.Unmarshal([]byte(`{
+		"discovery": {"interval": "30s", "max_interval": "5m"},
+		"topics": {"orders": {"replay_window": "1m"}}
+	}`), &conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Discovery.Interval != Duration(30*time.Second) || conf.Discovery.MaxInterval != Duration(5*time.Minute) {
+		t.Fatalf("discovery %+v", conf.Discovery)
+	}
+	if conf.Topics["orders"].ReplayWindow != Duration(time.Minute) {
+		t.Fatalf("topic %+v", conf.Topics["orders"])
+	}
+
+	var election ElectionConfig
+	var reliable ReliableConfig
+	var kv KVConfig
+	for v, data := range map[any]string{
+		&election: `{"lease": "10s", "heartbeat": "700ms"}`,
+		&reliable: `{"interval": "5s"}`,
+		&kv:       `{"interval": "30s", "max_skew": "1m"}`,
+	} {
+		if err = json.Unmarshal([]byte(data), v); err != nil {
+			t.Fatalf("%T: %v", v, err)
+		}
+	}
+	if election.Lease != Duration(10*time.Second) || election.Heartbeat != Duration(700*time.Millisecond) {
+		t.Fatalf("election %+v", election)
+	}
+	if reliable.Interval != Duration(5*time.Second) || kv.Interval != Duration(30*time.Second) || kv.MaxSkew != Duration(time.Minute) {
+		t.Fatalf("reliable %+v kv %+v", reliable, kv)
+	}
+}
+
+func TestRouterSelection(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if _, err := NewPubSub(ctx, log.Root(), &Config{Identity: t.TempDir() + "/identity", Router: "unknown"}); err == nil {
+		t.Fatal("expected unknown router error")
+	}
+
+	for _, router := range []string{RouterFloodSub, RouterGossipSub, RouterRandomSub} {
+		t.Run(router, func(t *testing.T) {
+			nodes := newTestNodes(t, ctx, 2, func(conf *Config) {
+				conf.Router = router
+				conf.GossipSub.Score = &PeerScoreConfig{}
+			})
+			topics := make([]*pubsub.Topic, 0, len(nodes))
+			for _, node := range nodes {
+				topic, err := node.Topic("router")
+				if err != nil {
+					t.Fatal(err)
+				}
+				topics = append(topics, topic)
+			}
+			sub, err := topics[1].Subscribe()
+			if err != nil {
+				t.Fatal(err)
+			}
+			waitFor(t, 5*time.Second, func() bool { return len(topics[0].ListPeers()) > 0 })
+			if err = topics[0].Publish(ctx, []byte("hello")); err != nil {
+				t.Fatal(err)
+			}
+			recvCtx, recvCancel := context.WithTimeout(ctx, 5*time.Second)
+			defer recvCancel()
+			msg, err := sub.Next(recvCtx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(msg.Data) != "hello" {
+				t.Fatalf("received %q", msg.Data)
+			}
+		})
+	}
+}
@@ -0,0 +1,333 @@
+package pubsub
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/p2p/discovery/util"
+	sync2 "github.com/yydsqu/tools/sync"
+	"io"
+	"iter"
+	"time"
+)
+
+const (
+	RPCProtocolPrefix = "/private_net/rpc/"
+	MaxFrameSize      = 4 << 20
+)
+
+const (
+	frameRequest byte = iota
+	frameData
+	frameError
+	frameEnd
+)
+
+var (
+	ErrNoProvider    = errors.New("rpc: no peer provides protocol")
+	ErrEmptyResponse = errors.New("rpc: empty response")
+	ErrFrameTooLarge = errors.New("rpc: frame too large")
+)
+
+// RemoteError
+// 对端 handler 返回的错误, 只保留错误信息
+type RemoteError struct {
+	Peer     peer.ID
+	Protocol protocol.ID
+	Message  string
+}
+
+func (e *RemoteError) Error() string {
+	return fmt.Sprintf("rpc %s from %s: %s", e.Protocol, e.Peer, e.Message)
+}
+
+type Handler[Req, Resp any] func(ctx context.Context, from peer.ID, req Req) (Resp, error)
+
+// StreamHandler
+// send 可以调用多次, 返回后流结束
+type StreamHandler[Req, Resp any] func(ctx context.Context, from peer.ID, req Req, send func(Resp) error) error
+
+func RPCProtocol(name string) protocol.ID {
+	return protocol.ID(RPCProtocolPrefix + name)
+}
+
+// writeFrame
+// 帧格式: 1 字节类型 + uvarint 长度 + 数据
+func writeFrame(w *bufio.Writer, kind byte, data []byte) error {
+	if len(data) > MaxFrameSize {
+		return fmt.Errorf("%w: %d bytes", ErrFrameTooLarge, len(data))
+	}
+	w.WriteByte(kind)
+	w.Write(binary.AppendUvarint(nil, uint64(len(data))))
+	w.Write(data)
+	return w.Flush()
+}
+
+func readFrame(r *bufio.Reader) (byte, []byte, error) {
+	kind, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if size > MaxFrameSize {
+		return 0, nil, fmt.Errorf("%w: %d bytes", ErrFrameTooLarge, size)
+	}
+	data := make([]byte, size)
+	if _, err = io.ReadFull(r, data); err != nil {
+		return 0, nil, err
+	}
+	return kind, data, nil
+}
+
+// Handle
+// 注册一问一答的 handler, 同时在 DHT 上以协议 ID 作为 namespace 广播
+func Handle[Req, Resp any](pubSub *PubSub, id protocol.ID, codec Codec, handler Handler[Req, Resp]) {
+	HandleStream(pubSub, id, codec, func(ctx context.Context, from peer.ID, req Req, send func(Resp) error) error {
+		resp, err := handler(ctx, from, req)
+		if err != nil {
+			return err
+		}
+		return send(resp)
+	})
+}
+
+func HandleStream[Req, Resp any](pubSub *PubSub, id protocol.ID, codec Codec, handler StreamHandler[Req, Resp]) {
+	if codec == nil {
+		codec = JSON
+	}
+	pubSub.handle(id, func(ctx context.Context, from peer.ID, data []byte, send func([]byte) error) error {
+		var req Req
+		if err := codec.Unmarshal(data, &req); err != nil {
+			return fmt.Errorf("decode %s request: %w", codec.Name(), err)
+		}
+		return handler(ctx, from, req, func(resp Resp) error {
+			data, err := codec.Marshal(resp)
+			if err != nil {
+				return fmt.Errorf("encode %s response: %w", codec.Name(), err)
+			}
+			return send(data)
+		})
+	})
+}
+
+func (pubSub *PubSub) handle(id protocol.ID, serve func(ctx context.Context, from peer.ID, data []byte, send func([]byte) error) error) {
+	pubSub.host.SetStreamHandler(id, func(s network.Stream) {
+		defer s.Close()
+		var (
+			from = s.Conn().RemotePeer()
+			r    = bufio.NewReader(s)
+			w    = bufio.NewWriter(s)
+		)
+		kind, data, err := readFrame(r)
+		if err != nil || kind != frameRequest || len(data) < 8 {
+			pubSub.logger.Trace("invalid rpc request", "protocol", id, "peer", from, "err", err)
+			s.Reset()
+			return
+		}
+		ctx, cancel := context.WithCancel(pubSub.ctx)
+		defer cancel()
+		// 客户端发送的是剩余时间, 按本地时钟换算成 deadline, 不受节点之间时钟误差影响
+		if timeout := time.Duration(binary.BigEndian.Uint64(data)); timeout > 0 {
+			deadline := time.Now().Add(timeout)
+			var cancelDeadline context.CancelFunc
+			ctx, cancelDeadline = context.WithDeadline(ctx, deadline)
+			defer cancelDeadline()
+			s.SetDeadline(deadline)
+		}
+		// 客户端写完请求后关闭写端, 读到 EOF 以外的错误说明客户端取消了调用
+		go func() {
+			if _, err := r.ReadByte(); !errors.Is(err, io.EOF) {
+				cancel()
+			}
+		}()
+
+		err = func() (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = sync2.NewPanicError(id, r)
+					pubSub.logger.Error("rpc handler panic", "protocol", id, "peer", from, "err", err)
+				}
+			}()
+			return serve(ctx, from, data[8:], func(data []byte) error {
+				return writeFrame(w, frameData, data)
+			})
+		}()
+		if err != nil {
+			writeFrame(w, frameError, []byte(err.Error()))
+			return
+		}
+		writeFrame(w, frameEnd, nil)
+	})
+	util.Advertise(pubSub.ctx, pubSub.discovery, string(id))
+}
+
+func (pubSub *PubSub) RemoveHandler(id protocol.ID) {
+	pubSub.host.RemoveStreamHandler(id)
+}
+
+// Client
+// ctx 的剩余时间会传给服务端, ctx 取消时重置流
+type Client[Req, Resp any] struct {
+	pubSub *PubSub
+	id     protocol.ID
+	codec  Codec
+}
+
+func (c *Client[Req, Resp]) Protocol() protocol.ID {
+	return c.id
+}
+
+// Find
+// 优先返回已连接且支持该协议的节点, 否则通过 DHT 查找并连接
+func (c *Client[Req, Resp]) Find(ctx context.Context) (peer.ID, error) {
+	h := c.pubSub.host
+	for _, p := range h.Network().Peers() {
+		if protocols, err := h.Peerstore().SupportsProtocols(p, c.id); err == nil && len(protocols) > 0 {
+			return p, nil
+		}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	peers, err := c.pubSub.discovery.FindPeers(ctx, string(c.id))
+	if err != nil {
+		return "", fmt.Errorf("find %s providers: %w", c.id, err)
+	}
+	for info := range peers {
+		if info.ID == h.ID() || len(info.Addrs) == 0 {
+			continue
+		}
+		if err = h.Connect(ctx, info); err != nil {
+			c.pubSub.logger.Trace("connect rpc provider failure", "protocol", c.id, "peer", info.ID, "err", err)
+			continue
+		}
+		return info.ID, nil
+	}
+	return "", fmt.Errorf("%w %s", ErrNoProvider, c.id)
+}
+
+func (c *Client[Req, Resp]) Call(ctx context.Context, p peer.ID, req Req) (Resp, error) {
+	var (
+		resp Resp
+		got  bool
+	)
+	for v, err := range c.Stream(ctx, p, req) {
+		if err != nil {
+			return resp, err
+		}
+		if !got {
+			resp, got = v, true
+		}
+	}
+	if !got {
+		return resp, ErrEmptyResponse
+	}
+	return resp, nil
+}
+
+// CallAny
+// 通过 Find 选择节点后调用
+func (c *Client[Req, Resp]) CallAny(ctx context.Context, req Req) (Resp, error) {
+	p, err := c.Find(ctx)
+	if err != nil {
+		var zero Resp
+		return zero, err
+	}
+	return c.Call(ctx, p, req)
+}
+
+// Stream
+// 出错时 yield 一次 error 后结束, 提前 break 会重置流
+func (c *Client[Req, Resp]) Stream(ctx context.Context, p peer.ID, req Req) iter.Seq2[Resp, error] {
+	return func(yield func(Resp, error) bool) {
+		var zero Resp
+		s, err := c.open(ctx, p, req)
+		if err != nil {
+			yield(zero, err)
+			return
+		}
+		stop := context.AfterFunc(ctx, func() {
+			s.Reset()
+		})
+		defer stop()
+
+		r := bufio.NewReader(s)
+		for {
+			kind, data, err := readFrame(r)
+			if err != nil {
+				s.Reset()
+				if ctx.Err() != nil {
+					err = context.Cause(ctx)
+				}
+				yield(zero, fmt.Errorf("rpc %s: %w", c.id, err))
+				return
+			}
+			switch kind {
+			case frameData:
+				var v Resp
+				if err = c.codec.Unmarshal(data, &v); err != nil {
+					s.Reset()
+					yield(zero, fmt.Errorf("decode %s response: %w", c.codec.Name(), err))
+					return
+				}
+				if !yield(v, nil) {
+					s.Reset()
+					return
+				}
+			case frameError:
+				s.Close()
+				yield(zero, &RemoteError{Peer: p, Protocol: c.id, Message: string(data)})
+				return
+			case frameEnd:
+				s.Close()
+				return
+			default:
+				s.Reset()
+				yield(zero, fmt.Errorf("rpc %s: unknown frame type %d", c.id, kind))
+				return
+			}
+		}
+	}
+}
+
+func (c *Client[Req, Resp]) open(ctx context.Context, p peer.ID, req Req) (network.Stream, error) {
+	data, err := c.codec.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("encode %s request: %w", c.codec.Name(), err)
+	}
+	s, err := c.pubSub.host.NewStream(ctx, p, c.id)
+	if err != nil {
+		return nil, fmt.Errorf("open %s stream: %w", c.id, err)
+	}
+	var header [8]byte
+	if deadline, ok := ctx.Deadline(); ok {
+		binary.BigEndian.PutUint64(header[:], uint64(max(time.Until(deadline), 1)))
+	}
+	if err = writeFrame(bufio.NewWriter(s), frameRequest, append(header[:], data...)); err != nil {
+		s.Reset()
+		return nil, fmt.Errorf("write %s request: %w", c.id, err)
+	}
+	if err = s.CloseWrite(); err != nil {
+		s.Reset()
+		return nil, fmt.Errorf("write %s request: %w", c.id, err)
+	}
+	return s, nil
+}
+
+func NewClient[Req, Resp any](pubSub *PubSub, id protocol.ID, codec Codec) *Client[Req, Resp] {
+	if codec == nil {
+		codec = JSON
+	}
+	return &Client[Req, Resp]{
+		pubSub: pubSub,
+		id:     id,
+		codec:  codec,
+	}
+}
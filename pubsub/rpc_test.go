@@ -0,0 +1,94 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+type echoRequest struct {
+	Text  string `json:"text"`
+	Count int    `json:"count"`
+}
+
+type echoResponse struct {
+	Text string `json:"text"`
+	Seq  int    `json:"seq"`
+}
+
+func TestRPC(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nodes := newTestNodes(t, ctx, 2)
+	server, caller := nodes[0], nodes[1]
+
+	echo, count, slow := RPCProtocol("echo"), RPCProtocol("count"), RPCProtocol("slow")
+	Handle(server, echo, nil, func(ctx context.Context, from peer.ID, req echoRequest) (echoResponse, error) {
+		if req.Text == "" {
+			return echoResponse{}, errors.New("empty text")
+		}
+		return echoResponse{Text: req.Text + " from " + from.String()}, nil
+	})
+	HandleStream(server, count, CBOR, func(ctx context.Context, from peer.ID, req echoRequest, send func(echoResponse) error) error {
+		for i := 0; i < req.Count; i++ {
+			if err := send(echoResponse{Text: req.Text, Seq: i}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	handlerDone := make(chan error, 1)
+	Handle(server, slow, nil, func(ctx context.Context, from peer.ID, req echoRequest) (echoResponse, error) {
+		<-ctx.Done()
+		handlerDone <- ctx.Err()
+		return echoResponse{}, ctx.Err()
+	})
+
+	client := NewClient[echoRequest, echoResponse](caller, echo, nil)
+	waitFor(t, 5*time.Second, func() bool {
+		p, err := client.Find(ctx)
+		return err == nil && p == server.Self()
+	})
+	resp, err := client.CallAny(ctx, echoRequest{Text: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Text != "hello from "+caller.Self().String() {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+	var remote *RemoteError
+	if _, err = client.Call(ctx, server.Self(), echoRequest{}); !errors.As(err, &remote) || remote.Message != "empty text" {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	seq := 0
+	for v, err := range NewClient[echoRequest, echoResponse](caller, count, CBOR).Stream(ctx, server.Self(), echoRequest{Text: "n", Count: 5}) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.Seq != seq {
+			t.Fatalf("unexpected sequence %d", v.Seq)
+		}
+		seq++
+	}
+	if seq != 5 {
+		t.Fatalf("received %d responses", seq)
+	}
+
+	callCtx, callCancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer callCancel()
+	if _, err = NewClient[echoRequest, echoResponse](caller, slow, nil).Call(callCtx, server.Self(), echoRequest{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	select {
+	case err = <-handlerDone:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("handler context ended with %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler context was not cancelled")
+	}
+}
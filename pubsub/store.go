@@ -0,0 +1,84 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	leveldb "github.com/ipfs/go-ds-leveldb"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoreds"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// knownAddrTTL 主动连接成功的地址保留的时间, 过期后从 peerstore 中删除
+	knownAddrTTL        = 24 * time.Hour
+	maxReconnectPeers   = 64
+	reconnectConcurrent = 8
+)
+
+var (
+	peerstoreKey = datastore.NewKey("/peerstore")
+	dhtKey       = datastore.NewKey("/dht")
+)
+
+// openStore
+// DataDir 为空时返回 nil, peerstore 和 dht 使用内存存储
+func (conf *Config) openStore() (datastore.Batching, error) {
+	if conf.DataDir == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(conf.DataDir, 0700); err != nil {
+		return nil, err
+	}
+	store, err := leveldb.NewDatastore(conf.DataDir, nil)
+	if err != nil {
+		return nil, fmt.Errorf("open datastore %s: %w", conf.DataDir, err)
+	}
+	return store, nil
+}
+
+func newPeerstore(ctx context.Context, store datastore.Batching) (peerstore.Peerstore, error) {
+	return pstoreds.NewPeerstore(ctx, namespace.Wrap(store, peerstoreKey), pstoreds.DefaultOpts())
+}
+
+// reconnect
+// 连接 peerstore 中保存的节点, 重启后不必等待 discovery. 最多连接 maxReconnectPeers 个节点,
+// 同时进行的连接不超过 reconnectConcurrent 个
+func (pubSub *PubSub) reconnect() {
+	var (
+		ps    = pubSub.host.Peerstore()
+		wg    sync.WaitGroup
+		slots = make(chan struct{}, reconnectConcurrent)
+		count = 0
+	)
+	for _, id := range ps.PeersWithAddrs() {
+		if id == pubSub.host.ID() || pubSub.host.Network().Connectedness(id) == network.Connected {
+			continue
+		}
+		if count++; count > maxReconnectPeers {
+			break
+		}
+		select {
+		case slots <- struct{}{}:
+		case <-pubSub.ctx.Done():
+			wg.Wait()
+			return
+		}
+		wg.Go(func() {
+			defer func() { <-slots }()
+			ctx, cancel := context.WithTimeout(pubSub.ctx, 10*time.Second)
+			defer cancel()
+			if err := pubSub.host.Connect(ctx, ps.PeerInfo(id)); err != nil {
+				pubSub.logger.Trace("reconnect known peer failure", "id", id, "err", err)
+				return
+			}
+			pubSub.logger.Trace("reconnected known peer", "id", id)
+		})
+	}
+	wg.Wait()
+}
@@ -0,0 +1,101 @@
+package pubsub
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/yydsqu/tools/log"
+)
+
+func TestPersistentPeerstore(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	remote := newTestNodes(t, ctx, 1)[0]
+	dir := t.TempDir()
+	conf := &Config{
+		Identity: filepath.Join(dir, "identity"),
+		DataDir:  filepath.Join(dir, "data"),
+		Listen:   []string{"/ip4/127.0.0.1/tcp/0"},
+	}
+
+	node, err := NewPubSub(ctx, log.Root(), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = node.Connect(ctx, peer.AddrInfo{ID: remote.Self(), Addrs: remote.Host().Addrs()}); err != nil {
+		t.Fatal(err)
+	}
+	if err = node.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	nodeCtx, nodeCancel := context.WithCancel(ctx)
+	defer nodeCancel()
+	node, err = NewPubSub(nodeCtx, log.Root(), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.Close()
+	if len(node.Host().Peerstore().Addrs(remote.Self())) == 0 {
+		t.Fatal("expected remote addresses to be restored")
+	}
+	go node.Start()
+	waitFor(t, 5*time.Second, func() bool {
+		return node.Host().Network().Connectedness(remote.Self()) == network.Connected
+	})
+}
+
+func TestStoreReleasedOnRouterFailure(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dir := t.TempDir()
+	conf := &Config{
+		Identity: filepath.Join(dir, "identity"),
+		DataDir:  filepath.Join(dir, "data"),
+		Listen:   []string{"/ip4/127.0.0.1/tcp/0"},
+		Router:   "unknown",
+	}
+	if _, err := NewPubSub(ctx, log.Root(), conf); err == nil {
+		t.Fatal("expected unknown router error")
+	}
+	conf.Router = ""
+	node, err := NewPubSub(ctx, log.Root(), conf)
+	if err != nil {
+		t.Fatalf("datastore still locked: %v", err)
+	}
+	node.Close()
+}
+
+type stubConn struct {
+	network.Conn
+	remote peer.ID
+	addr   multiaddr.Multiaddr
+	dir    network.Direction
+}
+
+func (c *stubConn) RemotePeer() peer.ID                  { return c.remote }
+func (c *stubConn) RemoteMultiaddr() multiaddr.Multiaddr { return c.addr }
+func (c *stubConn) Stat() network.ConnStats {
+	return network.ConnStats{Stats: network.Stats{Direction: c.dir}}
+}
+
+func TestInboundAddrNotRecorded(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	node := newTestNodes(t, ctx, 1)[0]
+	inbound, outbound := peer.ID("inbound"), peer.ID("outbound")
+	addr := multiaddr.StringCast("/ip4/10.0.0.1/tcp/4001")
+	node.Connected(node.Host().Network(), &stubConn{remote: inbound, addr: addr, dir: network.DirInbound})
+	node.Connected(node.Host().Network(), &stubConn{remote: outbound, addr: addr, dir: network.DirOutbound})
+	if addrs := node.Host().Peerstore().Addrs(inbound); len(addrs) != 0 {
+		t.Fatalf("inbound address recorded: %v", addrs)
+	}
+	if addrs := node.Host().Peerstore().Addrs(outbound); len(addrs) != 1 {
+		t.Fatalf("outbound address not recorded: %v", addrs)
+	}
+}
@@ -0,0 +1,134 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yydsqu/tools/log"
+	"iter"
+	"sync/atomic"
+)
+
+var (
+	decodeErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "pubsub_decode_errors_total",
+			Help: "Total number of pubsub messages that failed to decode, partitioned by topic and codec.",
+			ConstLabels: map[string]string{
+				"nodename": log.Hostname,
+			},
+		},
+		[]string{"topic", "codec"},
+	)
+)
+
+type Message[T any] struct {
+	From  peer.ID
+	Value T
+	Raw   *pubsub.Message
+}
+
+// TypedTopic
+// 用 Codec 编解码的 topic, 解码失败的消息计数后丢弃
+type TypedTopic[T any] struct {
+	pubSub       *PubSub
+	name         string
+	topic        *pubsub.Topic
+	codec        Codec
+	logger       log.Logger
+	decodeErrors atomic.Uint64
+}
+
+func (t *TypedTopic[T]) Name() string {
+	return t.name
+}
+
+func (t *TypedTopic[T]) Topic() *pubsub.Topic {
+	return t.topic
+}
+
+func (t *TypedTopic[T]) DecodeErrors() uint64 {
+	return t.decodeErrors.Load()
+}
+
+func (t *TypedTopic[T]) Publish(ctx context.Context, v T, opts ...pubsub.PubOpt) error {
+	data, err := t.codec.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("encode %s message: %w", t.codec.Name(), err)
+	}
+	return t.topic.Publish(ctx, data, opts...)
+}
+
+// Subscribe
+// 返回的迭代器在 ctx 结束或 break 后取消订阅, 包含本节点自己发布的消息
+func (t *TypedTopic[T]) Subscribe(ctx context.Context, opts ...pubsub.SubOpt) (iter.Seq[Message[T]], error) {
+	sub, err := t.topic.Subscribe(opts...)
+	if err != nil {
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, sub.Cancel)
+	return func(yield func(Message[T]) bool) {
+		defer func() {
+			stop()
+			sub.Cancel()
+		}()
+		for {
+			msg, err := sub.Next(ctx)
+			if err != nil {
+				return
+			}
+			var v T
+			if err = t.codec.Unmarshal(msg.Data, &v); err != nil {
+				t.decodeErrors.Add(1)
+				decodeErrors.WithLabelValues(t.name, t.codec.Name()).Inc()
+				t.logger.Trace("decode message failure", "topic", t.name, "peer", msg.GetFrom(), "err", err)
+				continue
+			}
+			if !yield(Message[T]{From: msg.GetFrom(), Value: v, Raw: msg}) {
+				return
+			}
+		}
+	}, nil
+}
+
+// Validate
+// 注册 schema 校验, 无法解码或 fn 返回 error 的消息在转发前被拒绝, fn 可以为 nil
+func (t *TypedTopic[T]) Validate(fn func(T) error) error {
+	return t.pubSub.AddValidator(t.name, func(ctx context.Context, msg *pubsub.Message) error {
+		var v T
+		if err := t.codec.Unmarshal(msg.Data, &v); err != nil {
+			return fmt.Errorf("decode %s message: %w", t.codec.Name(), err)
+		}
+		if fn == nil {
+			return nil
+		}
+		return fn(v)
+	})
+}
+
+func (t *TypedTopic[T]) Close() error {
+	return t.topic.Close()
+}
+
+func NewTypedTopic[T any](pubSub *PubSub, name string, codec Codec, opts ...pubsub.TopicOpt) (*TypedTopic[T], error) {
+	if codec == nil {
+		codec = JSON
+	}
+	topic, err := pubSub.Topic(name, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &TypedTopic[T]{
+		pubSub: pubSub,
+		name:   name,
+		topic:  topic,
+		codec:  codec,
+		logger: pubSub.logger,
+	}, nil
+}
+
+func init() {
+	prometheus.Register(decodeErrors)
+}
@@ -0,0 +1,84 @@
+package pubsub
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type testConfig struct {
+	Name  string `json:"name" cbor:"name"`
+	Count int    `json:"count" cbor:"count"`
+}
+
+func TestCodec(t *testing.T) {
+	value := testConfig{Name: strings.Repeat("node", 64), Count: 3}
+	for _, codec := range []Codec{JSON, CBOR, Zstd(JSON, 0), Zstd(CBOR, 0)} {
+		data, err := codec.Marshal(value)
+		if err != nil {
+			t.Fatalf("%s: %v", codec.Name(), err)
+		}
+		var decoded testConfig
+		if err = codec.Unmarshal(data, &decoded); err != nil {
+			t.Fatalf("%s: %v", codec.Name(), err)
+		}
+		if decoded != value {
+			t.Fatalf("%s: decoded %+v", codec.Name(), decoded)
+		}
+	}
+
+	data, err := Proto.Marshal(wrapperspb.String("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg *wrapperspb.StringValue
+	if err = Proto.Unmarshal(data, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.GetValue() != "hello" {
+		t.Fatalf("decoded %q", msg.GetValue())
+	}
+
+	if err = Zstd(JSON, 16).Unmarshal(Zstd(JSON, 0).(*zstdCodec).encoder.EncodeAll(make([]byte, 1024), nil), &value); err == nil {
+		t.Fatal("expected oversized message to be rejected")
+	}
+}
+
+func TestTypedTopic(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nodes := newTestNodes(t, ctx, 2)
+
+	pub, err := NewTypedTopic[testConfig](nodes[0], "typed", Zstd(CBOR, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := NewTypedTopic[testConfig](nodes[1], "typed", Zstd(CBOR, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages, err := sub.Subscribe(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 5*time.Second, func() bool { return len(pub.Topic().ListPeers()) > 0 })
+
+	if err = pub.Topic().Publish(ctx, []byte("garbage")); err != nil {
+		t.Fatal(err)
+	}
+	if err = pub.Publish(ctx, testConfig{Name: "proxy", Count: 2}); err != nil {
+		t.Fatal(err)
+	}
+	for msg := range messages {
+		if msg.From != nodes[0].Self() || msg.Value.Name != "proxy" || msg.Value.Count != 2 {
+			t.Fatalf("unexpected message %+v", msg)
+		}
+		break
+	}
+	if sub.DecodeErrors() != 1 {
+		t.Fatalf("decode errors %d", sub.DecodeErrors())
+	}
+}
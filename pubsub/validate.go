@@ -0,0 +1,314 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SignStrict     = "strict_sign"
+	SignStrictNone = "strict_no_sign"
+	SignLax        = "lax_sign"
+	SignLaxNone    = "lax_no_sign"
+)
+
+var (
+	ErrMessageTooLarge       = errors.New("pubsub: message too large")
+	ErrReplayedMessage       = errors.New("pubsub: replayed message")
+	ErrUnauthorizedPublisher = errors.New("pubsub: unauthorized publisher")
+)
+
+// Validator
+// 返回 error 时拒绝消息, 本节点发布的消息同样会经过校验
+type Validator func(ctx context.Context, msg *pubsub.Message) error
+
+// rejectWindow
+// 节点的被拒绝次数只在这个时间内累计
+const rejectWindow = 10 * time.Minute
+
+// internalTopics
+// 选举、可靠 topic、KV 和日志的 topic 由集群中所有节点发布, 不使用 Config.Publishers,
+// 需要限制时在 Config.Topics 中单独配置
+var internalTopics = []string{ElectionTopicPrefix, ReliableTopicPrefix, KVTopicPrefix, LogTopic}
+
+func internalTopic(topic string) bool {
+	for _, prefix := range internalTopics {
+		if strings.HasPrefix(topic, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// TopicConfig
+// Publishers 为空时使用 Config.Publishers(内部 topic 除外), Schema 可选 json 或 cbor.
+// ReplayWindow 内相同 ID 的消息只接受一次, 更早的重放无法识别, 需要时效性的消息应在内容中携带时间戳自行检查
+type TopicConfig struct {
+	MaxSize      int      `json:"max_size" toml:"max_size" yaml:"max_size"`
+	ReplayWindow Duration `json:"replay_window" toml:"replay_window" yaml:"replay_window"`
+	Publishers   []string `json:"publishers" toml:"publishers" yaml:"publishers"`
+	Schema       string   `json:"schema" toml:"schema" yaml:"schema"`
+}
+
+func (conf *Config) signaturePolicy() (pubsub.MessageSignaturePolicy, error) {
+	switch strings.ToLower(conf.SignaturePolicy) {
+	case SignStrict, "strict", "":
+		return pubsub.StrictSign, nil
+	case SignStrictNone:
+		return pubsub.StrictNoSign, nil
+	case SignLax:
+		return pubsub.LaxSign, nil
+	case SignLaxNone:
+		return pubsub.LaxNoSign, nil
+	default:
+		return 0, fmt.Errorf("unknown signature policy %q", conf.SignaturePolicy)
+	}
+}
+
+func decodePeers(ids []string) (map[peer.ID]struct{}, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	peers := make(map[peer.ID]struct{}, len(ids))
+	for _, s := range ids {
+		id, err := peer.Decode(s)
+		if err != nil {
+			return nil, fmt.Errorf("decode peer id %q: %w", s, err)
+		}
+		peers[id] = struct{}{}
+	}
+	return peers, nil
+}
+
+// blacklist
+// libp2p 的 MapBlacklist 只在 pubsub 事件循环中访问, Blacklisted 需要加锁
+type blacklist struct {
+	mutex sync.RWMutex
+	peers map[peer.ID]struct{}
+}
+
+func (b *blacklist) Add(id peer.ID) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.peers[id] = struct{}{}
+	return true
+}
+
+func (b *blacklist) Contains(id peer.ID) bool {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	_, ok := b.peers[id]
+	return ok
+}
+
+// guard
+// 每个 topic 只能注册一个 libp2p validator, 由 guard 依次执行授权、大小、重放和自定义校验
+type guard struct {
+	topic      string
+	maxSize    int
+	window     time.Duration
+	schema     Codec
+	publishers map[peer.ID]struct{}
+	mutex      sync.Mutex
+	seen       map[string]time.Time
+	swept      time.Time
+	validators []Validator
+	// once 保证内部 topic 的内置校验只注册一次, 同名 topic 重新创建时复用
+	once sync.Once
+}
+
+func (g *guard) add(v Validator) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.validators = append(g.validators, v)
+}
+
+func (g *guard) check(ctx context.Context, msg *pubsub.Message) error {
+	if g.publishers != nil {
+		if _, ok := g.publishers[msg.GetFrom()]; !ok {
+			return fmt.Errorf("%w: %s", ErrUnauthorizedPublisher, msg.GetFrom())
+		}
+	}
+	if g.maxSize > 0 && len(msg.Data) > g.maxSize {
+		return fmt.Errorf("%w: %d > %d bytes", ErrMessageTooLarge, len(msg.Data), g.maxSize)
+	}
+	if g.schema != nil {
+		var v any
+		if err := g.schema.Unmarshal(msg.Data, &v); err != nil {
+			return fmt.Errorf("invalid %s message: %w", g.schema.Name(), err)
+		}
+	}
+	if g.window > 0 && g.replayed(msg, time.Now()) {
+		return ErrReplayedMessage
+	}
+	g.mutex.Lock()
+	validators := g.validators
+	g.mutex.Unlock()
+	for _, v := range validators {
+		if err := v(ctx, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replayed
+// window 内按消息 ID 去重. libp2p 的 seqno 只在 PubSub 创建时取一次时间戳, 之后逐条递增,
+// 不能代表发布时间, 所以不按 seqno 判断消息新旧
+func (g *guard) replayed(msg *pubsub.Message, now time.Time) bool {
+	id := msg.ID
+	if id == "" {
+		id = pubsub.DefaultMsgIdFn(msg.Message)
+	}
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if now.Sub(g.swept) > g.window {
+		for k, at := range g.seen {
+			if now.Sub(at) > g.window {
+				delete(g.seen, k)
+			}
+		}
+		g.swept = now
+	}
+	if at, ok := g.seen[id]; ok && now.Sub(at) <= g.window {
+		return true
+	}
+	g.seen[id] = now
+	return false
+}
+
+func (pubSub *PubSub) newGuard(topic string) (*guard, error) {
+	conf := pubSub.conf.Topics[topic]
+	g := &guard{
+		topic:   topic,
+		maxSize: conf.MaxSize,
+		window:  time.Duration(conf.ReplayWindow),
+		seen:    make(map[string]time.Time),
+	}
+	if !internalTopic(topic) {
+		g.publishers = pubSub.publishers
+	}
+	switch strings.ToLower(conf.Schema) {
+	case "":
+	case "json":
+		g.schema = JSON
+	case "cbor":
+		g.schema = CBOR
+	default:
+		return nil, fmt.Errorf("topic %s: unknown schema %q", topic, conf.Schema)
+	}
+	if len(conf.Publishers) > 0 {
+		var err error
+		if g.publishers, err = decodePeers(conf.Publishers); err != nil {
+			return nil, fmt.Errorf("topic %s: %w", topic, err)
+		}
+	}
+	return g, nil
+}
+
+// topicGuard
+// 第一次使用 topic 时注册 validator
+func (pubSub *PubSub) topicGuard(topic string) (*guard, error) {
+	pubSub.guardMutex.Lock()
+	defer pubSub.guardMutex.Unlock()
+	if g, ok := pubSub.guards[topic]; ok {
+		return g, nil
+	}
+	g, err := pubSub.newGuard(topic)
+	if err != nil {
+		return nil, err
+	}
+	validate := func(ctx context.Context, from peer.ID, msg *pubsub.Message) pubsub.ValidationResult {
+		err := g.check(ctx, msg)
+		switch {
+		case err == nil:
+			if from == pubSub.Self() {
+				messagesPublished.WithLabelValues(topic).Inc()
+			}
+			return pubsub.ValidationAccept
+		case errors.Is(err, ErrReplayedMessage):
+			pubSub.logger.Trace("ignore replayed message", "topic", topic, "peer", from)
+			return pubsub.ValidationIgnore
+		default:
+			pubSub.reject(topic, from, err)
+			return pubsub.ValidationReject
+		}
+	}
+	// inline 校验不额外启动 goroutine, 避免同一节点发布的消息乱序
+	if err = pubSub.pubSub.RegisterTopicValidator(topic, pubsub.ValidatorEx(validate), pubsub.WithValidatorInline(true)); err != nil {
+		return nil, fmt.Errorf("register validator for %s: %w", topic, err)
+	}
+	pubSub.guards[topic] = g
+	return g, nil
+}
+
+// AddValidator
+// 追加 topic 的自定义校验, 按添加顺序在内置校验之后执行
+func (pubSub *PubSub) AddValidator(topic string, v Validator) error {
+	g, err := pubSub.topicGuard(topic)
+	if err != nil {
+		return err
+	}
+	g.add(v)
+	return nil
+}
+
+type rejectCount struct {
+	count int
+	since time.Time
+}
+
+// reject
+// from 是转发消息的节点, rejectWindow 内被拒绝次数达到 Config.RejectLimit 后加入黑名单
+func (pubSub *PubSub) reject(topic string, from peer.ID, err error) {
+	pubSub.logger.Debug("reject message", "topic", topic, "peer", from, "err", err)
+	if from == pubSub.Self() || pubSub.conf.RejectLimit <= 0 {
+		return
+	}
+	if pubSub.countReject(from, time.Now()) {
+		pubSub.BlacklistPeer(from)
+	}
+}
+
+// countReject
+// 达到 RejectLimit 时返回 true 并删除计数, 过期的计数定期清理
+func (pubSub *PubSub) countReject(from peer.ID, now time.Time) bool {
+	pubSub.guardMutex.Lock()
+	defer pubSub.guardMutex.Unlock()
+	if now.Sub(pubSub.rejectSwept) > rejectWindow {
+		for id, r := range pubSub.rejects {
+			if now.Sub(r.since) > rejectWindow {
+				delete(pubSub.rejects, id)
+			}
+		}
+		pubSub.rejectSwept = now
+	}
+	r, ok := pubSub.rejects[from]
+	if !ok || now.Sub(r.since) > rejectWindow {
+		r = &rejectCount{since: now}
+		pubSub.rejects[from] = r
+	}
+	r.count++
+	if r.count < pubSub.conf.RejectLimit {
+		return false
+	}
+	delete(pubSub.rejects, from)
+	return true
+}
+
+// BlacklistPeer
+// 丢弃该节点的所有消息, 重启前一直有效
+func (pubSub *PubSub) BlacklistPeer(id peer.ID) {
+	pubSub.logger.Warn("blacklist peer", "peer", id)
+	pubSub.pubSub.BlacklistPeer(id)
+}
+
+func (pubSub *PubSub) Blacklisted(id peer.ID) bool {
+	return pubSub.blacklist.Contains(id)
+}
@@ -0,0 +1,179 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	pb "github.com/libp2p/go-libp2p-pubsub/pb"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestGuardCheck(t *testing.T) {
+	allowed, other := peer.ID("allowed"), peer.ID("other")
+	g := &guard{
+		maxSize:    8,
+		window:     time.Minute,
+		schema:     JSON,
+		publishers: map[peer.ID]struct{}{allowed: {}},
+		seen:       make(map[string]time.Time),
+	}
+	message := func(from peer.ID, seqno byte, data string) *pubsub.Message {
+		return &pubsub.Message{Message: &pb.Message{From: []byte(from), Seqno: []byte{seqno}, Data: []byte(data)}}
+	}
+	ctx := context.Background()
+
+	if err := g.check(ctx, message(allowed, 1, `"ok"`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.check(ctx, message(other, 2, `"ok"`)); !errors.Is(err, ErrUnauthorizedPublisher) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := g.check(ctx, message(allowed, 3, `"too large"`)); !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := g.check(ctx, message(allowed, 4, `{`)); err == nil {
+		t.Fatal("expected invalid json to be rejected")
+	}
+	if err := g.check(ctx, message(allowed, 1, `"ok"`)); !errors.Is(err, ErrReplayedMessage) {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	errOdd := errors.New("odd")
+	g.add(func(ctx context.Context, msg *pubsub.Message) error {
+		if msg.Seqno[0]%2 == 1 {
+			return errOdd
+		}
+		return nil
+	})
+	if err := g.check(ctx, message(allowed, 5, `1`)); !errors.Is(err, errOdd) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := g.check(ctx, message(allowed, 6, `1`)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGuardReplayWindow(t *testing.T) {
+	g := &guard{window: time.Minute, seen: make(map[string]time.Time)}
+	now := time.Now()
+	// 运行很久的节点的 seqno 仍然是 PubSub 创建时的时间戳加上消息数
+	started := now.Add(-time.Hour)
+	message := func(n uint64) *pubsub.Message {
+		seqno := binary.BigEndian.AppendUint64(nil, uint64(started.UnixNano())+n)
+		return &pubsub.Message{Message: &pb.Message{From: []byte("peer"), Seqno: seqno}}
+	}
+	if g.replayed(message(1), now) || g.replayed(message(2), now) {
+		t.Fatal("message of long running node rejected")
+	}
+	if !g.replayed(message(1), now.Add(time.Second)) {
+		t.Fatal("duplicate message within window accepted")
+	}
+	if g.replayed(message(1), now.Add(2*time.Minute)) {
+		t.Fatal("expired message id still remembered")
+	}
+}
+
+func TestInternalTopicPublishers(t *testing.T) {
+	allowed := map[peer.ID]struct{}{"allowed": {}}
+	pub := &PubSub{
+		conf:       &Config{Topics: map[string]TopicConfig{KVTopicPrefix + "locked": {Publishers: []string{"12D3KooWGRUVh9X6v6vDvUZnqZJ7cHQBPeKJPD3QH6HsrGvqQjMu"}}}},
+		publishers: allowed,
+	}
+	for topic, restricted := range map[string]bool{
+		"orders":                     true,
+		ElectionTopicPrefix + "lead": false,
+		ReliableTopicPrefix + "jobs": false,
+		KVTopicPrefix + "config":     false,
+		LogTopic:                     false,
+		KVTopicPrefix + "locked":     true,
+	} {
+		g, err := pub.newGuard(topic)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (g.publishers != nil) != restricted {
+			t.Fatalf("%s: publishers %v", topic, g.publishers)
+		}
+	}
+}
+
+func TestRejectDecay(t *testing.T) {
+	pub := &PubSub{conf: &Config{RejectLimit: 3}, rejects: make(map[peer.ID]*rejectCount)}
+	now := time.Now()
+	pub.countReject("a", now)
+	pub.countReject("a", now)
+	if pub.countReject("a", now.Add(rejectWindow+time.Second)) {
+		t.Fatal("expired rejects counted")
+	}
+	pub.countReject("a", now.Add(rejectWindow+2*time.Second))
+	if !pub.countReject("a", now.Add(rejectWindow+3*time.Second)) {
+		t.Fatal("limit not reached")
+	}
+	pub.countReject("b", now)
+	pub.countReject("c", now.Add(3*rejectWindow))
+	if _, ok := pub.rejects["b"]; ok || len(pub.rejects) != 1 {
+		t.Fatalf("stale rejects not swept: %d", len(pub.rejects))
+	}
+}
+
+func TestSignaturePolicy(t *testing.T) {
+	for policy, want := range map[string]pubsub.MessageSignaturePolicy{
+		"":             pubsub.StrictSign,
+		SignStrictNone: pubsub.StrictNoSign,
+		SignLax:        pubsub.LaxSign,
+	} {
+		conf := &Config{SignaturePolicy: policy}
+		if got, err := conf.signaturePolicy(); err != nil || got != want {
+			t.Fatalf("%q: got %v, %v", policy, got, err)
+		}
+	}
+	if _, err := (&Config{SignaturePolicy: "none"}).signaturePolicy(); err == nil {
+		t.Fatal("expected unknown policy to fail")
+	}
+}
+
+func TestTopicValidation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nodes := newTestNodes(t, ctx, 2, func(conf *Config) {
+		conf.RejectLimit = 1
+		conf.Topics = map[string]TopicConfig{"guarded": {MaxSize: 32}}
+	})
+
+	pub, err := NewTypedTopic[testConfig](nodes[0], "guarded", JSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := NewTypedTopic[testConfig](nodes[1], "guarded", JSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sub.Validate(func(v testConfig) error {
+		if v.Count < 0 {
+			return errors.New("negative count")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = sub.Subscribe(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = pub.Publish(ctx, testConfig{Name: "too long for the size limit"}); err == nil {
+		t.Fatal("expected oversized local message to be rejected")
+	}
+
+	waitFor(t, 5*time.Second, func() bool {
+		return len(pub.Topic().ListPeers()) > 0
+	})
+	if err = pub.Publish(ctx, testConfig{Count: -1}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 5*time.Second, func() bool {
+		return nodes[1].Blacklisted(nodes[0].Self())
+	})
+}
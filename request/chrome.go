@@ -24,6 +24,10 @@ func (chrome *Chrome) RoundTrip(req *http.Request) (*http.Response, error) {
 	return chrome.parent.RoundTrip(req)
 }
 
+func (chrome *Chrome) CloseIdleConnections() {
+	CloseIdleConnections(chrome.parent)
+}
+
 func ChromeTransport(parent http.RoundTripper) http.RoundTripper {
 	return &Chrome{
 		parent: parent,
@@ -78,6 +78,10 @@ func (encoding *Encoding) RoundTrip(req *http.Request) (*http.Response, error) {
 	return resp, nil
 }
 
+func (encoding *Encoding) CloseIdleConnections() {
+	CloseIdleConnections(encoding.parent)
+}
+
 func EncodingTransport(parent http.RoundTripper) http.RoundTripper {
 	return &Encoding{
 		parent:         parent,
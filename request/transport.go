@@ -9,6 +9,18 @@ import (
 
 type WarpTransport func(parent http.RoundTripper) http.RoundTripper
 
+type closeIdler interface {
+	CloseIdleConnections()
+}
+
+// CloseIdleConnections
+// 关闭 tripper 及其包装链上的空闲连接, http.Client.CloseIdleConnections 会调用到这里
+func CloseIdleConnections(tripper http.RoundTripper) {
+	if c, ok := tripper.(closeIdler); ok {
+		c.CloseIdleConnections()
+	}
+}
+
 type RoundRobinProxy struct {
 	transports []http.RoundTripper
 	round      *balancer.RoundRobin[http.RoundTripper]
@@ -18,6 +30,12 @@ func (p *RoundRobinProxy) RoundTrip(request *http.Request) (*http.Response, erro
 	return p.round.Next().RoundTrip(request)
 }
 
+func (p *RoundRobinProxy) CloseIdleConnections() {
+	for _, tripper := range p.round.Items() {
+		CloseIdleConnections(tripper)
+	}
+}
+
 func RoundRobinTransport(transports ...http.RoundTripper) http.RoundTripper {
 	robin, _ := balancer.NewRoundRobin[http.RoundTripper](transports...)
 	return &RoundRobinProxy{
@@ -3,8 +3,6 @@ package sync
 import (
 	"context"
 	"errors"
-	"fmt"
-	"runtime/debug"
 	"sync"
 )
 
@@ -22,7 +20,7 @@ func GroupWithContext[T any](ctx context.Context, fns ...func(ctx context.Contex
 			return func() {
 				defer func() {
 					if recove := recover(); recove != nil {
-						ch <- &Result[T]{Err: fmt.Errorf("panic:%v\nstack:%s", recove, debug.Stack())}
+						ch <- &Result[T]{Err: NewPanicError(nil, recove)}
 					}
 				}()
 				r, err := fn(ctx)
@@ -63,7 +61,7 @@ func GroupGenericWithContext[P any, R any](ctx context.Context, seeds []P, fn fu
 			return func() {
 				defer func() {
 					if recove := recover(); recove != nil {
-						ch <- &Result[R]{Err: fmt.Errorf("seed:%v\npanic: %v\nstack:%s", seed, recove, debug.Stack())}
+						ch <- &Result[R]{Err: NewPanicError(seed, recove)}
 					}
 				}()
 				r, err := fn(ctx, seed)
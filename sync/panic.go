@@ -0,0 +1,38 @@
+package sync
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// PanicError
+// recover 捕获到的 panic, 保留原始值和完整堆栈
+type PanicError struct {
+	Seed  any
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	if e.Seed == nil {
+		return fmt.Sprintf("panic: %v", e.Value)
+	}
+	return fmt.Sprintf("seed:%v panic: %v", e.Seed, e.Value)
+}
+
+func (e *PanicError) Unwrap() error {
+	if err, ok := e.Value.(error); ok {
+		return err
+	}
+	return nil
+}
+
+// NewPanicError
+// 必须在 defer 的 recover 中调用, 才能拿到触发 panic 的堆栈
+func NewPanicError(seed any, value any) *PanicError {
+	return &PanicError{
+		Seed:  seed,
+		Value: value,
+		Stack: debug.Stack(),
+	}
+}
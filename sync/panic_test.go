@@ -0,0 +1,45 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPanicError(t *testing.T) {
+	_, err := GroupGenericWithContext(
+		context.Background(),
+		[]int{1, 2},
+		func(ctx context.Context, seed int) (int, error) {
+			if seed == 2 {
+				panic("boom")
+			}
+			return seed, nil
+		},
+	)
+	var panicErr *PanicError
+	if !errors.As(err, &panicErr) {
+		t.Fatalf("expected *PanicError, got %v", err)
+	}
+	if panicErr.Seed != 2 || panicErr.Value != "boom" {
+		t.Fatalf("unexpected seed or value: %v %v", panicErr.Seed, panicErr.Value)
+	}
+	if !strings.Contains(string(panicErr.Stack), "TestPanicError") {
+		t.Fatalf("stack does not contain caller:\n%s", panicErr.Stack)
+	}
+}
+
+func TestPanicErrorUnwrap(t *testing.T) {
+	cause := errors.New("cause")
+	_, err := RacerGenericWithContext(
+		context.Background(),
+		[]int{1},
+		func(ctx context.Context, seed int) (int, error) {
+			panic(cause)
+		},
+	)
+	if !errors.Is(err, cause) {
+		t.Fatalf("expected panic value to unwrap, got %v", err)
+	}
+}
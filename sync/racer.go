@@ -3,8 +3,6 @@ package sync
 import (
 	"context"
 	"errors"
-	"fmt"
-	"runtime/debug"
 	"sync"
 )
 
@@ -27,7 +25,7 @@ func RacerGenericWithContext[P any, R any](parent context.Context, seeds []P, fn
 				defer func() {
 					if recove := recover(); recove != nil {
 						select {
-						case ch <- &Result[R]{Err: fmt.Errorf("seed:%v\npanic: %v\n stack:%s", seed, recove, debug.Stack())}:
+						case ch <- &Result[R]{Err: NewPanicError(seed, recove)}:
 						case <-ctx.Done():
 						}
 					}
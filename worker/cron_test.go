@@ -0,0 +1,59 @@
+package worker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSpec(t *testing.T) {
+	base := time.Date(2026, 3, 14, 10, 7, 30, 0, time.UTC)
+	cases := []struct {
+		expr string
+		want []time.Time
+	}{
+		{"*/15 * * * *", []time.Time{
+			time.Date(2026, 3, 14, 10, 15, 0, 0, time.UTC),
+			time.Date(2026, 3, 14, 10, 30, 0, 0, time.UTC),
+		}},
+		{"30 9 * * mon-fri", []time.Time{
+			time.Date(2026, 3, 16, 9, 30, 0, 0, time.UTC),
+			time.Date(2026, 3, 17, 9, 30, 0, 0, time.UTC),
+		}},
+		{"0 0 31 * *", []time.Time{
+			time.Date(2026, 3, 31, 0, 0, 0, 0, time.UTC),
+			time.Date(2026, 5, 31, 0, 0, 0, 0, time.UTC),
+		}},
+		{"*/20 * * * * *", []time.Time{
+			time.Date(2026, 3, 14, 10, 7, 40, 0, time.UTC),
+			time.Date(2026, 3, 14, 10, 8, 0, 0, time.UTC),
+		}},
+		{"@daily", []time.Time{
+			time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC),
+			time.Date(2026, 3, 16, 0, 0, 0, 0, time.UTC),
+		}},
+		{"@every 90s", []time.Time{
+			time.Date(2026, 3, 14, 10, 9, 0, 0, time.UTC),
+			time.Date(2026, 3, 14, 10, 10, 30, 0, time.UTC),
+		}},
+	}
+	for _, c := range cases {
+		spec, err := ParseSpec(c.expr)
+		if err != nil {
+			t.Fatalf("%s: %v", c.expr, err)
+		}
+		next := base
+		for _, want := range c.want {
+			if next = spec.Next(next); !next.Equal(want) {
+				t.Fatalf("%s: got %s, want %s", c.expr, next, want)
+			}
+		}
+	}
+}
+
+func TestParseSpecInvalid(t *testing.T) {
+	for _, expr := range []string{"", "* * *", "60 * * * *", "*/0 * * * *", "5-1 * * * *", "@every x"} {
+		if _, err := ParseSpec(expr); err == nil {
+			t.Fatalf("%q: expected error", expr)
+		}
+	}
+}
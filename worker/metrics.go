@@ -0,0 +1,98 @@
+package worker
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yydsqu/tools/log"
+	"sync"
+)
+
+var (
+	restartCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "worker_restarts_total",
+			Help: "Total number of worker restarts, partitioned by worker.",
+			ConstLabels: map[string]string{
+				"nodename": log.Hostname,
+			},
+		},
+		[]string{"worker"},
+	)
+	collector = &workerCollector{
+		uptime: prometheus.NewDesc(
+			"worker_uptime_seconds",
+			"Seconds since the worker entered the running state, 0 when not running.",
+			[]string{"worker"},
+			prometheus.Labels{"nodename": log.Hostname},
+		),
+		state: prometheus.NewDesc(
+			"worker_state",
+			"Current worker state, 1 for the active state and 0 otherwise.",
+			[]string{"worker", "state"},
+			prometheus.Labels{"nodename": log.Hostname},
+		),
+		workers: make(map[string]*Worker),
+	}
+)
+
+type workerCollector struct {
+	uptime  *prometheus.Desc
+	state   *prometheus.Desc
+	mutex   sync.Mutex
+	workers map[string]*Worker
+}
+
+// register
+// 同名 worker 同时运行时, 后注册的依次使用 name#2、name#3 作为标签, 返回实际使用的标签
+func (c *workerCollector) register(sw *Worker) string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	label := sw.name
+	for i := 2; ; i++ {
+		if w, ok := c.workers[label]; !ok || w == sw {
+			break
+		}
+		label = fmt.Sprintf("%s#%d", sw.name, i)
+	}
+	c.workers[label] = sw
+	return label
+}
+
+func (c *workerCollector) unregister(label string, sw *Worker) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.workers[label] == sw {
+		delete(c.workers, label)
+	}
+}
+
+func (c *workerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.uptime
+	ch <- c.state
+}
+
+func (c *workerCollector) Collect(ch chan<- prometheus.Metric) {
+	// 先复制再读取状态, 避免与持有 stateMutex 的 register 互相等待
+	c.mutex.Lock()
+	workers := make(map[string]*Worker, len(c.workers))
+	for name, sw := range c.workers {
+		workers[name] = sw
+	}
+	c.mutex.Unlock()
+	for name, sw := range workers {
+		ch <- prometheus.MustNewConstMetric(c.uptime, prometheus.GaugeValue, sw.Uptime().Seconds(), name)
+		current := sw.State()
+		for _, state := range states {
+			var v float64
+			if state == current {
+				v = 1
+			}
+			ch <- prometheus.MustNewConstMetric(c.state, prometheus.GaugeValue, v, name, state.String())
+		}
+	}
+}
+
+func init() {
+	prometheus.Register(restartCounter)
+	prometheus.Register(collector)
+}
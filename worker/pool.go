@@ -0,0 +1,309 @@
+package worker
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	sync2 "github.com/yydsqu/tools/sync"
+	"sync"
+	"time"
+)
+
+var (
+	ErrPoolClosed   = errors.New("pool: closed")
+	ErrJobAbandoned = errors.New("pool: job abandoned")
+)
+
+type Job[R any] func(ctx context.Context) (R, error)
+
+// Priority
+// 高优先级队列中的任务总是先于低优先级被取出
+type Priority int
+
+const (
+	PriorityLow Priority = iota
+	PriorityNormal
+	PriorityHigh
+	priorities
+)
+
+type PoolConfig struct {
+	Workers   int           `json:"workers" toml:"workers" yaml:"workers"`
+	QueueSize int           `json:"queue_size" toml:"queue_size" yaml:"queue_size"`
+	Timeout   time.Duration `json:"timeout" toml:"timeout" yaml:"timeout"`
+}
+
+type jobOptions struct {
+	priority Priority
+	timeout  time.Duration
+}
+
+type JobOption func(*jobOptions)
+
+func WithPriority(priority Priority) JobOption {
+	return func(o *jobOptions) {
+		o.priority = min(max(priority, PriorityLow), PriorityHigh)
+	}
+}
+
+// WithTimeout
+// 覆盖 PoolConfig.Timeout, 从任务开始执行时计时
+func WithTimeout(timeout time.Duration) JobOption {
+	return func(o *jobOptions) {
+		o.timeout = timeout
+	}
+}
+
+type Future[R any] interface {
+	Done() <-chan struct{}
+	Get(ctx context.Context) (R, error)
+}
+
+type future[R any] struct {
+	done chan struct{}
+	once sync.Once
+	val  R
+	err  error
+}
+
+func (f *future[R]) Done() <-chan struct{} {
+	return f.done
+}
+
+func (f *future[R]) Get(ctx context.Context) (R, error) {
+	select {
+	case <-f.done:
+		return f.val, f.err
+	case <-ctx.Done():
+		var zero R
+		return zero, ctx.Err()
+	}
+}
+
+func (f *future[R]) resolve(val R, err error) {
+	f.once.Do(func() {
+		f.val, f.err = val, err
+		close(f.done)
+	})
+}
+
+type task[R any] struct {
+	ctx     context.Context
+	job     Job[R]
+	timeout time.Duration
+	future  *future[R]
+}
+
+// Pool
+// 固定数量的 goroutine 从有界优先级队列中取任务执行, 任务 panic 不影响其他任务
+type Pool[R any] struct {
+	conf    PoolConfig
+	ctx     context.Context
+	cancel  context.CancelCauseFunc
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	lanes   [priorities][]*task[R]
+	queued  int
+	size    int
+	running int
+	closed  bool
+	closing chan struct{}
+	slots   chan struct{}
+	wg      sync.WaitGroup
+}
+
+// Submit
+// 队列已满时阻塞直到有空位、ctx 结束或 pool 关闭, 任务的 ctx 继承自 ctx
+func (p *Pool[R]) Submit(ctx context.Context, job Job[R], opts ...JobOption) (Future[R], error) {
+	o := jobOptions{
+		priority: PriorityNormal,
+		timeout:  p.conf.Timeout,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	select {
+	case <-p.closing:
+		return nil, ErrPoolClosed
+	default:
+	}
+	select {
+	case p.slots <- struct{}{}:
+	case <-p.closing:
+		return nil, ErrPoolClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.closed {
+		<-p.slots
+		return nil, ErrPoolClosed
+	}
+	f := &future[R]{done: make(chan struct{})}
+	p.lanes[o.priority] = append(p.lanes[o.priority], &task[R]{
+		ctx:     ctx,
+		job:     job,
+		timeout: o.timeout,
+		future:  f,
+	})
+	p.queued++
+	p.cond.Signal()
+	return f, nil
+}
+
+// Resize
+// 调整 goroutine 数量, 多余的 goroutine 在当前任务完成后退出
+func (p *Pool[R]) Resize(n int) {
+	n = max(n, 1)
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.closed {
+		return
+	}
+	p.size = n
+	for p.running < p.size {
+		p.spawn()
+	}
+	p.cond.Broadcast()
+}
+
+func (p *Pool[R]) Size() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.size
+}
+
+func (p *Pool[R]) Queued() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.queued
+}
+
+// Shutdown
+// 停止接收新任务并等待队列排空, ctx 到期后放弃剩余任务并取消执行中的任务
+func (p *Pool[R]) Shutdown(ctx context.Context) error {
+	p.mutex.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.closing)
+		p.cond.Broadcast()
+	}
+	p.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		p.cancel(ErrPoolClosed)
+		return nil
+	case <-ctx.Done():
+		p.abandon()
+		return ctx.Err()
+	}
+}
+
+func (p *Pool[R]) abandon() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	var zero R
+	for i := range p.lanes {
+		for _, t := range p.lanes[i] {
+			t.future.resolve(zero, ErrJobAbandoned)
+			<-p.slots
+		}
+		p.lanes[i] = nil
+	}
+	p.queued = 0
+	p.cancel(ErrJobAbandoned)
+	p.cond.Broadcast()
+}
+
+func (p *Pool[R]) spawn() {
+	p.running++
+	p.wg.Add(1)
+	go p.work()
+}
+
+func (p *Pool[R]) work() {
+	defer p.wg.Done()
+	for {
+		p.mutex.Lock()
+		for p.queued == 0 && !p.closed && p.running <= p.size {
+			p.cond.Wait()
+		}
+		if p.running > p.size || p.queued == 0 {
+			p.running--
+			p.mutex.Unlock()
+			return
+		}
+		t := p.pop()
+		p.mutex.Unlock()
+		<-p.slots
+		p.execute(t)
+	}
+}
+
+func (p *Pool[R]) pop() *task[R] {
+	for i := len(p.lanes) - 1; i >= 0; i-- {
+		if len(p.lanes[i]) == 0 {
+			continue
+		}
+		t := p.lanes[i][0]
+		p.lanes[i][0] = nil
+		p.lanes[i] = p.lanes[i][1:]
+		p.queued--
+		return t
+	}
+	return nil
+}
+
+func (p *Pool[R]) execute(t *task[R]) {
+	var zero R
+	ctx, cancel := context.WithCancelCause(t.ctx)
+	defer cancel(nil)
+	stop := context.AfterFunc(p.ctx, func() {
+		cancel(context.Cause(p.ctx))
+	})
+	defer stop()
+	if t.timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, t.timeout)
+		defer cancelTimeout()
+	}
+	if err := ctx.Err(); err != nil {
+		t.future.resolve(zero, context.Cause(ctx))
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			t.future.resolve(zero, sync2.NewPanicError(nil, r))
+		}
+	}()
+	t.future.resolve(t.job(ctx))
+}
+
+func NewPool[R any](conf PoolConfig) *Pool[R] {
+	conf.Workers = max(conf.Workers, 1)
+	conf.QueueSize = cmp.Or(conf.QueueSize, 1024)
+	p := &Pool[R]{
+		conf:    conf,
+		size:    conf.Workers,
+		closing: make(chan struct{}),
+		slots:   make(chan struct{}, conf.QueueSize),
+	}
+	p.ctx, p.cancel = context.WithCancelCause(context.Background())
+	p.cond = sync.NewCond(&p.mutex)
+	p.mutex.Lock()
+	for p.running < p.size {
+		p.spawn()
+	}
+	p.mutex.Unlock()
+	return p
+}
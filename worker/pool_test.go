@@ -0,0 +1,149 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	sync2 "github.com/yydsqu/tools/sync"
+	"testing"
+	"time"
+)
+
+func TestPoolPriority(t *testing.T) {
+	pool := NewPool[int](PoolConfig{Workers: 1, QueueSize: 8})
+	defer pool.Shutdown(context.Background())
+
+	block := make(chan struct{})
+	started := make(chan struct{})
+	pool.Submit(context.Background(), func(ctx context.Context) (int, error) {
+		close(started)
+		<-block
+		return 0, nil
+	})
+	<-started
+
+	order := make(chan int, 3)
+	record := func(n int) Job[int] {
+		return func(ctx context.Context) (int, error) {
+			order <- n
+			return n, nil
+		}
+	}
+	pool.Submit(context.Background(), record(1), WithPriority(PriorityLow))
+	pool.Submit(context.Background(), record(2))
+	last, _ := pool.Submit(context.Background(), record(3), WithPriority(PriorityHigh))
+	close(block)
+
+	for _, want := range []int{3, 2, 1} {
+		if got := <-order; got != want {
+			t.Fatalf("ran %d, want %d", got, want)
+		}
+	}
+	if v, err := last.Get(context.Background()); v != 3 || err != nil {
+		t.Fatalf("future returned %d %v", v, err)
+	}
+}
+
+func TestPoolTimeoutAndPanic(t *testing.T) {
+	pool := NewPool[int](PoolConfig{Workers: 2})
+	defer pool.Shutdown(context.Background())
+
+	slow, _ := pool.Submit(context.Background(), func(ctx context.Context) (int, error) {
+		<-ctx.Done()
+		return 0, ctx.Err()
+	}, WithTimeout(10*time.Millisecond))
+	if _, err := slow.Get(context.Background()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	crash, _ := pool.Submit(context.Background(), func(ctx context.Context) (int, error) {
+		panic("boom")
+	})
+	var panicErr *sync2.PanicError
+	if _, err := crash.Get(context.Background()); !errors.As(err, &panicErr) {
+		t.Fatalf("expected panic error, got %v", err)
+	}
+
+	ok, _ := pool.Submit(context.Background(), func(ctx context.Context) (int, error) {
+		return 1, nil
+	})
+	if v, err := ok.Get(context.Background()); v != 1 || err != nil {
+		t.Fatalf("pool broken after panic: %d %v", v, err)
+	}
+}
+
+func TestPoolBoundedQueue(t *testing.T) {
+	pool := NewPool[int](PoolConfig{Workers: 1, QueueSize: 1})
+	block := make(chan struct{})
+	started := make(chan struct{})
+	pool.Submit(context.Background(), func(ctx context.Context) (int, error) {
+		close(started)
+		<-block
+		return 0, nil
+	})
+	<-started
+	pool.Submit(context.Background(), func(ctx context.Context) (int, error) { return 0, nil })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := pool.Submit(ctx, func(ctx context.Context) (int, error) { return 0, nil }); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected full queue to block, got %v", err)
+	}
+	close(block)
+	if err := pool.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pool.Submit(context.Background(), nil); !errors.Is(err, ErrPoolClosed) {
+		t.Fatalf("expected ErrPoolClosed, got %v", err)
+	}
+}
+
+func TestPoolResize(t *testing.T) {
+	pool := NewPool[int](PoolConfig{Workers: 1})
+	defer pool.Shutdown(context.Background())
+	pool.Resize(4)
+
+	release := make(chan struct{})
+	running := make(chan struct{}, 4)
+	for i := 0; i < 4; i++ {
+		pool.Submit(context.Background(), func(ctx context.Context) (int, error) {
+			running <- struct{}{}
+			<-release
+			return 0, nil
+		})
+	}
+	for i := 0; i < 4; i++ {
+		select {
+		case <-running:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("only %d jobs running concurrently", i)
+		}
+	}
+	close(release)
+	pool.Resize(1)
+	if pool.Size() != 1 {
+		t.Fatalf("size %d", pool.Size())
+	}
+}
+
+func TestPoolShutdownAbandon(t *testing.T) {
+	pool := NewPool[int](PoolConfig{Workers: 1})
+	running, _ := pool.Submit(context.Background(), func(ctx context.Context) (int, error) {
+		<-ctx.Done()
+		return 0, context.Cause(ctx)
+	})
+	queued, _ := pool.Submit(context.Background(), func(ctx context.Context) (int, error) {
+		return 1, nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := pool.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if _, err := queued.Get(context.Background()); !errors.Is(err, ErrJobAbandoned) {
+		t.Fatalf("queued job: %v", err)
+	}
+	if _, err := running.Get(context.Background()); !errors.Is(err, ErrJobAbandoned) {
+		t.Fatalf("running job: %v", err)
+	}
+}
@@ -0,0 +1,214 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Clock
+// 测试时可替换为手动推进的时钟
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+var SystemClock Clock = systemClock{}
+
+// OverlapPolicy
+// 上一次运行尚未结束时新的触发如何处理
+type OverlapPolicy int
+
+const (
+	// OverlapSkip 丢弃本次触发
+	OverlapSkip OverlapPolicy = iota
+	// OverlapQueue 排队, 上一次结束后依次运行
+	OverlapQueue
+)
+
+func (p OverlapPolicy) String() string {
+	switch p {
+	case OverlapSkip:
+		return "skip"
+	case OverlapQueue:
+		return "queue"
+	default:
+		return fmt.Sprintf("overlap(%d)", int(p))
+	}
+}
+
+func (p OverlapPolicy) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+func (p *OverlapPolicy) UnmarshalText(data []byte) error {
+	switch strings.ToLower(string(data)) {
+	case "skip", "":
+		*p = OverlapSkip
+	case "queue":
+		*p = OverlapQueue
+	default:
+		return fmt.Errorf("unknown overlap policy %q", data)
+	}
+	return nil
+}
+
+type ScheduleConfig struct {
+	Name     string        `json:"name" toml:"name" yaml:"name"`
+	Spec     string        `json:"spec" toml:"spec" yaml:"spec"`
+	Jitter   time.Duration `json:"jitter" toml:"jitter" yaml:"jitter"`
+	Overlap  OverlapPolicy `json:"overlap" toml:"overlap" yaml:"overlap"`
+	CatchUp  int           `json:"catch_up" toml:"catch_up" yaml:"catch_up"`
+	TimeZone string        `json:"time_zone" toml:"time_zone" yaml:"time_zone"`
+	Clock    Clock         `json:"-" toml:"-" yaml:"-"`
+}
+
+// Schedule
+// 按 cron 表达式或固定间隔周期运行 handle, 通过内嵌的 Worker 启停
+type Schedule struct {
+	*Worker
+	conf    ScheduleConfig
+	spec    Spec
+	loc     *time.Location
+	clock   Clock
+	handle  Handle
+	mutex   sync.Mutex
+	running bool
+	pending int
+}
+
+// NextRuns
+// 预览接下来 n 次计划运行时间, 不含抖动
+func (s *Schedule) NextRuns(n int) []time.Time {
+	runs := make([]time.Time, 0, n)
+	t := s.clock.Now().In(s.loc)
+	for i := 0; i < n; i++ {
+		if t = s.spec.Next(t); t.IsZero() {
+			break
+		}
+		runs = append(runs, t)
+	}
+	return runs
+}
+
+func (s *Schedule) loop(ctx context.Context) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	next := s.spec.Next(s.clock.Now().In(s.loc))
+	for !next.IsZero() {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.clock.After(next.Sub(s.clock.Now()) + s.jitter()):
+		}
+
+		// 进程挂起或运行阻塞导致错过的计划, 最多补跑 CatchUp 次
+		var (
+			now       = s.clock.Now().In(s.loc)
+			following = s.spec.Next(next)
+			runs      = 1
+			missed    = 0
+		)
+		for !following.IsZero() && !following.After(now) {
+			if runs <= s.conf.CatchUp {
+				runs++
+			} else {
+				missed++
+			}
+			following = s.spec.Next(following)
+		}
+		if missed > 0 {
+			s.log().Warn("schedule missed runs", "worker", s.Name(), "missed", missed)
+		}
+		s.trigger(ctx, &wg, runs)
+		next = following
+	}
+}
+
+func (s *Schedule) trigger(ctx context.Context, wg *sync.WaitGroup, runs int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.running {
+		if s.conf.Overlap == OverlapQueue {
+			s.pending += runs
+		} else {
+			s.log().Debug("schedule skipped overlapping run", "worker", s.Name())
+		}
+		return
+	}
+	s.running = true
+	s.pending = runs - 1
+	wg.Go(func() {
+		for {
+			s.run(ctx)
+			s.mutex.Lock()
+			if s.pending == 0 || ctx.Err() != nil {
+				s.running, s.pending = false, 0
+				s.mutex.Unlock()
+				return
+			}
+			s.pending--
+			s.mutex.Unlock()
+		}
+	})
+}
+
+// run
+// 单次运行 panic 只记录日志, 不影响后续调度
+func (s *Schedule) run(ctx context.Context) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.log().Error("schedule run panic", "worker", s.Name(), "err", s.panicError(r))
+		}
+	}()
+	s.handle(ctx)
+}
+
+func (s *Schedule) jitter() time.Duration {
+	if s.conf.Jitter <= 0 {
+		return 0
+	}
+	return rand.N(s.conf.Jitter)
+}
+
+func NewSchedule(conf ScheduleConfig, handle Handle) (*Schedule, error) {
+	spec, err := ParseSpec(conf.Spec)
+	if err != nil {
+		return nil, err
+	}
+	loc := time.Local
+	if conf.TimeZone != "" {
+		if loc, err = time.LoadLocation(conf.TimeZone); err != nil {
+			return nil, fmt.Errorf("load time zone %q: %w", conf.TimeZone, err)
+		}
+	}
+	s := &Schedule{
+		conf:   conf,
+		spec:   spec,
+		loc:    loc,
+		clock:  conf.Clock,
+		handle: handle,
+	}
+	if s.clock == nil {
+		s.clock = SystemClock
+	}
+	if conf.Name != "" {
+		s.Worker = NewNamedWorker(conf.Name, s.loop)
+	} else {
+		s.Worker = NewWorker(s.loop)
+	}
+	return s, nil
+}
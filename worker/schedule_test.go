@@ -0,0 +1,160 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeWaiter struct {
+	deadline time.Time
+	ch       chan time.Time
+}
+
+type fakeClock struct {
+	mutex   sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, fakeWaiter{c.now.Add(d), ch})
+	return ch
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = c.now.Add(d)
+	waiters := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.deadline.After(c.now) {
+			waiters = append(waiters, w)
+		} else {
+			w.ch <- c.now
+		}
+	}
+	c.waiters = waiters
+}
+
+// BlockUntil
+// 等待调度循环进入 After
+func (c *fakeClock) BlockUntil(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		c.mutex.Lock()
+		waiting := len(c.waiters)
+		c.mutex.Unlock()
+		if waiting >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("clock has %d waiters, want %d", waiting, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func TestScheduleNextRuns(t *testing.T) {
+	s, err := NewSchedule(ScheduleConfig{
+		Spec:     "0 9 * * *",
+		TimeZone: "Asia/Shanghai",
+		Clock:    newFakeClock(),
+	}, func(ctx context.Context) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runs := s.NextRuns(2)
+	if len(runs) != 2 {
+		t.Fatalf("got %d runs", len(runs))
+	}
+	for i, want := range []time.Time{
+		time.Date(2026, 1, 1, 1, 0, 0, 0, time.UTC),
+		time.Date(2026, 1, 2, 1, 0, 0, 0, time.UTC),
+	} {
+		if !runs[i].Equal(want) {
+			t.Fatalf("run %d at %s, want %s", i, runs[i].UTC(), want)
+		}
+	}
+}
+
+func TestScheduleOverlap(t *testing.T) {
+	for _, c := range []struct {
+		overlap OverlapPolicy
+		want    int32
+	}{
+		{OverlapSkip, 1},
+		{OverlapQueue, 3},
+	} {
+		t.Run(c.overlap.String(), func(t *testing.T) {
+			var (
+				clock   = newFakeClock()
+				runs    atomic.Int32
+				release = make(chan struct{})
+			)
+			s, _ := NewSchedule(ScheduleConfig{
+				Spec:    "@every 1m",
+				Overlap: c.overlap,
+				Clock:   clock,
+			}, func(ctx context.Context) {
+				runs.Add(1)
+				<-release
+			})
+			s.Start()
+			for i := 0; i < 3; i++ {
+				clock.BlockUntil(t, 1)
+				clock.Advance(time.Minute)
+			}
+			clock.BlockUntil(t, 1)
+			close(release)
+			waitFor(t, func() bool { return runs.Load() == c.want })
+			s.Stop(Finish)
+			s.Wait()
+			if got := runs.Load(); got != c.want {
+				t.Fatalf("ran %d times, want %d", got, c.want)
+			}
+		})
+	}
+}
+
+func TestScheduleCatchUp(t *testing.T) {
+	var (
+		clock = newFakeClock()
+		runs  atomic.Int32
+	)
+	s, _ := NewSchedule(ScheduleConfig{
+		Spec:    "@every 1m",
+		CatchUp: 2,
+		Clock:   clock,
+	}, func(ctx context.Context) {
+		runs.Add(1)
+	})
+	s.Start()
+	defer s.Stop(Finish)
+	clock.BlockUntil(t, 1)
+	clock.Advance(10 * time.Minute)
+	waitFor(t, func() bool { return runs.Load() == 3 })
+	clock.BlockUntil(t, 1)
+	clock.Advance(time.Minute)
+	waitFor(t, func() bool { return runs.Load() == 4 })
+}
@@ -0,0 +1,101 @@
+package worker
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// State
+// Idle -> Starting -> Running -> Stopping -> Stopped
+// 任意运行中的状态 handle panic 后进入 Crashed, Stopped 与 Crashed 可以重新 Starting.
+// Restart(false) 或 Stop 后立即 Start 时旧 handle 可能还没返回, 新的运行从 Stopping 直接进入 Starting
+type State int32
+
+const (
+	Idle State = iota
+	Starting
+	Running
+	Stopping
+	Stopped
+	Crashed
+)
+
+var states = []State{Idle, Starting, Running, Stopping, Stopped, Crashed}
+
+func (s State) String() string {
+	switch s {
+	case Idle:
+		return "idle"
+	case Starting:
+		return "starting"
+	case Running:
+		return "running"
+	case Stopping:
+		return "stopping"
+	case Stopped:
+		return "stopped"
+	case Crashed:
+		return "crashed"
+	default:
+		return fmt.Sprintf("state(%d)", int32(s))
+	}
+}
+
+func (s State) canTransition(to State) bool {
+	switch s {
+	case Idle, Stopped, Crashed:
+		return to == Starting
+	case Starting:
+		return to == Running || to == Stopping || to == Stopped || to == Crashed
+	case Running:
+		return to == Stopping || to == Stopped || to == Crashed
+	case Stopping:
+		return to == Stopped || to == Crashed
+	default:
+		return false
+	}
+}
+
+type Event struct {
+	Worker string
+	From   State
+	To     State
+	Err    error
+	Time   time.Time
+}
+
+type eventBus struct {
+	mutex sync.Mutex
+	subs  map[chan Event]struct{}
+}
+
+func (bus *eventBus) subscribe(size int) (<-chan Event, func()) {
+	ch := make(chan Event, max(size, 1))
+	bus.mutex.Lock()
+	if bus.subs == nil {
+		bus.subs = make(map[chan Event]struct{})
+	}
+	bus.subs[ch] = struct{}{}
+	bus.mutex.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			bus.mutex.Lock()
+			delete(bus.subs, ch)
+			bus.mutex.Unlock()
+			close(ch)
+		})
+	}
+}
+
+func (bus *eventBus) publish(event Event) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	for ch := range bus.subs {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
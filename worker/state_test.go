@@ -0,0 +1,175 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	sync2 "github.com/yydsqu/tools/sync"
+	"testing"
+	"time"
+)
+
+func nextEvent(t *testing.T, events <-chan Event) Event {
+	t.Helper()
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event")
+		return Event{}
+	}
+}
+
+func TestWorkerStateMachine(t *testing.T) {
+	release := make(chan struct{})
+	w := NewNamedWorker("state", func(ctx context.Context) {
+		select {
+		case <-ctx.Done():
+		case <-release:
+			panic("boom")
+		}
+	})
+	events, cancel := w.Subscribe(16)
+	defer cancel()
+	if w.State() != Idle {
+		t.Fatalf("new worker state %s", w.State())
+	}
+
+	w.Start()
+	for _, want := range []State{Starting, Running} {
+		if e := nextEvent(t, events); e.To != want {
+			t.Fatalf("got %s, want %s", e.To, want)
+		}
+	}
+	if w.Uptime() <= 0 {
+		t.Fatal("running worker has no uptime")
+	}
+
+	w.Stop(Finish)
+	w.Wait()
+	for _, want := range []State{Stopping, Stopped} {
+		if e := nextEvent(t, events); e.To != want || !errors.Is(e.Err, Finish) {
+			t.Fatalf("got %s %v, want %s", e.To, e.Err, want)
+		}
+	}
+
+	w.Start()
+	nextEvent(t, events)
+	nextEvent(t, events)
+	close(release)
+	e := nextEvent(t, events)
+	var panicErr *sync2.PanicError
+	if e.To != Crashed || !errors.As(e.Err, &panicErr) {
+		t.Fatalf("got %s %v, want crashed", e.To, e.Err)
+	}
+	if w.Restarts() != 1 || w.Uptime() != 0 {
+		t.Fatalf("restarts %d uptime %s", w.Restarts(), w.Uptime())
+	}
+}
+
+// runningWorkers
+// 返回导出为 running 的 worker 标签
+func runningWorkers() map[string]bool {
+	ch := make(chan prometheus.Metric, 256)
+	collector.Collect(ch)
+	close(ch)
+	running := map[string]bool{}
+	for m := range ch {
+		var out dto.Metric
+		m.Write(&out)
+		labels := map[string]string{}
+		for _, l := range out.Label {
+			labels[l.GetName()] = l.GetValue()
+		}
+		if labels["state"] == "running" && out.GetGauge().GetValue() == 1 {
+			running[labels["worker"]] = true
+		}
+	}
+	return running
+}
+
+func waitRunning(t *testing.T, w *Worker) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for w.State() != Running {
+		if time.Now().After(deadline) {
+			t.Fatalf("worker %s state %s", w.Name(), w.State())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWorkerMetrics(t *testing.T) {
+	w := NewNamedWorker("metrics", func(ctx context.Context) {
+		<-ctx.Done()
+	})
+	w.Start()
+	defer w.Stop(Finish)
+	waitRunning(t, w)
+	if !runningWorkers()["metrics"] {
+		t.Fatal("running state not exported")
+	}
+}
+
+func TestWorkerMetricsLifecycle(t *testing.T) {
+	handle := func(ctx context.Context) {
+		<-ctx.Done()
+	}
+	a, b := NewNamedWorker("dup", handle), NewNamedWorker("dup", handle)
+	a.Start()
+	waitRunning(t, a)
+	b.Start()
+	waitRunning(t, b)
+	if running := runningWorkers(); !running["dup"] || !running["dup#2"] {
+		t.Fatalf("duplicate names not both exported: %v", running)
+	}
+
+	a.Restart(true)
+	waitRunning(t, a)
+	if running := runningWorkers(); !running["dup"] || !running["dup#2"] {
+		t.Fatalf("restart changed labels: %v", running)
+	}
+
+	a.Stop(Finish)
+	a.Wait()
+	b.Stop(Finish)
+	b.Wait()
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+	for label, sw := range collector.workers {
+		if sw == a || sw == b {
+			t.Fatalf("stopped worker %s still registered", label)
+		}
+	}
+}
+
+func TestWorkerRestartBeforeExit(t *testing.T) {
+	release := make(chan struct{})
+	w := NewNamedWorker("restart", func(ctx context.Context) {
+		<-ctx.Done()
+		<-release
+	})
+	w.Start()
+	waitRunning(t, w)
+
+	// 旧 handle 返回前开始新的运行
+	w.Restart(false)
+	waitRunning(t, w)
+	w.Stop(Canceled)
+	w.Start()
+	waitRunning(t, w)
+	close(release)
+	time.Sleep(50 * time.Millisecond)
+	if w.State() != Running || !w.Status() {
+		t.Fatalf("state %s status %v", w.State(), w.Status())
+	}
+	if !runningWorkers()["restart"] {
+		t.Fatal("running state not exported")
+	}
+	w.Stop(Finish)
+	w.Wait()
+	if w.State() != Stopped {
+		t.Fatalf("state %s after stop", w.State())
+	}
+}
@@ -0,0 +1,334 @@
+package worker
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/yydsqu/tools/log"
+	"github.com/yydsqu/tools/utils"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrTooManyRestarts   = errors.New("supervisor: too many restarts")
+	ErrSupervisorStarted = errors.New("supervisor: already started")
+	ErrDuplicateWorker   = errors.New("supervisor: duplicate worker name")
+)
+
+// Strategy
+// 子 worker 异常退出后的重启策略
+type Strategy int
+
+const (
+	// OneForOne 只重启退出的 worker
+	OneForOne Strategy = iota
+	// OneForAll 重启全部 worker
+	OneForAll
+	// RestForOne 重启退出的 worker 以及在它之后添加的 worker
+	RestForOne
+)
+
+func (s Strategy) String() string {
+	switch s {
+	case OneForOne:
+		return "one_for_one"
+	case OneForAll:
+		return "one_for_all"
+	case RestForOne:
+		return "rest_for_one"
+	default:
+		return fmt.Sprintf("strategy(%d)", int(s))
+	}
+}
+
+func (s Strategy) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *Strategy) UnmarshalText(data []byte) error {
+	switch strings.ToLower(string(data)) {
+	case "one_for_one", "":
+		*s = OneForOne
+	case "one_for_all":
+		*s = OneForAll
+	case "rest_for_one":
+		*s = RestForOne
+	default:
+		return fmt.Errorf("unknown strategy %q", data)
+	}
+	return nil
+}
+
+type SupervisorConfig struct {
+	Strategy    Strategy      `json:"strategy" toml:"strategy" yaml:"strategy"`
+	MinBackoff  time.Duration `json:"min_backoff" toml:"min_backoff" yaml:"min_backoff"`
+	MaxBackoff  time.Duration `json:"max_backoff" toml:"max_backoff" yaml:"max_backoff"`
+	MaxRestarts int           `json:"max_restarts" toml:"max_restarts" yaml:"max_restarts"`
+	Window      time.Duration `json:"window" toml:"window" yaml:"window"`
+}
+
+type child struct {
+	worker  *Worker
+	backoff *utils.Backoff
+	gen     uint64
+}
+
+type exit struct {
+	index int
+	gen   uint64
+}
+
+// Supervisor
+// 管理一组具名 worker, 按添加顺序启动, 按相反顺序停止
+type Supervisor struct {
+	conf     SupervisorConfig
+	mutex    sync.Mutex
+	children []*child
+	names    map[string]int
+	restarts []time.Time
+	exits    chan exit
+	ctx      context.Context
+	cancel   context.CancelFunc
+	done     chan struct{}
+	err      error
+	logger   log.Logger
+	events   eventBus
+}
+
+// Add
+// 只能在 Start 之前调用
+func (s *Supervisor) Add(name string, handle Handle) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.ctx != nil {
+		return ErrSupervisorStarted
+	}
+	if _, ok := s.names[name]; ok {
+		return fmt.Errorf("%w: %s", ErrDuplicateWorker, name)
+	}
+	w := NewNamedWorker(name, handle)
+	w.parent = &s.events
+	w.logger = s.logger
+	s.names[name] = len(s.children)
+	s.children = append(s.children, &child{
+		worker:  w,
+		backoff: utils.NewBackoff(s.conf.MinBackoff, s.conf.MaxBackoff),
+	})
+	return nil
+}
+
+func (s *Supervisor) Start() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.ctx != nil {
+		return ErrSupervisorStarted
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	for i := range s.children {
+		s.startChild(i)
+	}
+	go s.loop()
+	return nil
+}
+
+// Stop
+// 按添加顺序的逆序停止全部 worker, ctx 到期后不再等待
+func (s *Supervisor) Stop(ctx context.Context) error {
+	s.mutex.Lock()
+	cancel := s.cancel
+	s.mutex.Unlock()
+	if cancel == nil {
+		return nil
+	}
+	cancel()
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Supervisor) Done() <-chan struct{} {
+	return s.done
+}
+
+// Wait
+// 等待 supervisor 退出, 超出重启强度时返回 ErrTooManyRestarts
+func (s *Supervisor) Wait() error {
+	<-s.done
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.err
+}
+
+func (s *Supervisor) Worker(name string) (*Worker, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	i, ok := s.names[name]
+	if !ok {
+		return nil, false
+	}
+	return s.children[i].worker, true
+}
+
+// Subscribe
+// 订阅全部子 worker 的状态变化事件
+func (s *Supervisor) Subscribe(size int) (<-chan Event, func()) {
+	return s.events.subscribe(size)
+}
+
+// SetLogger
+// 同时作用于已添加和之后添加的 worker
+func (s *Supervisor) SetLogger(logger log.Logger) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.logger = logger
+	for _, c := range s.children {
+		c.worker.SetLogger(logger)
+	}
+}
+
+func (s *Supervisor) Names() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	names := make([]string, 0, len(s.children))
+	for _, c := range s.children {
+		names = append(names, c.worker.Name())
+	}
+	return names
+}
+
+func (s *Supervisor) loop() {
+	defer close(s.done)
+	for {
+		select {
+		case <-s.ctx.Done():
+			s.shutdown()
+			return
+		case e := <-s.exits:
+			if e.gen != s.children[e.index].gen {
+				continue
+			}
+			if err := s.restart(e.index); err != nil {
+				s.mutex.Lock()
+				s.err = err
+				s.mutex.Unlock()
+				s.cancel()
+				s.shutdown()
+				return
+			}
+		}
+	}
+}
+
+func (s *Supervisor) restart(index int) error {
+	now := time.Now()
+	restarts := s.restarts[:0]
+	for _, t := range s.restarts {
+		if now.Sub(t) < s.conf.Window {
+			restarts = append(restarts, t)
+		}
+	}
+	s.restarts = append(restarts, now)
+	c := s.children[index]
+	if len(s.restarts) > s.conf.MaxRestarts {
+		err := fmt.Errorf("%w: %s: %w", ErrTooManyRestarts, c.worker.Name(), c.worker.Err())
+		s.log().Error("supervisor giving up", "worker", c.worker.Name(), "restarts", len(s.restarts), "window", s.conf.Window, "err", err)
+		return err
+	}
+
+	targets := s.targets(index)
+	for i := len(targets) - 1; i >= 0; i-- {
+		s.stopChild(targets[i], Restart)
+	}
+
+	delay := c.backoff.Next()
+	s.log().Warn("supervisor restarting workers", "worker", c.worker.Name(), "strategy", s.conf.Strategy, "count", len(targets), "delay", delay)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-s.ctx.Done():
+		return nil
+	case <-timer.C:
+	}
+
+	for _, i := range targets {
+		s.startChild(i)
+	}
+	return nil
+}
+
+func (s *Supervisor) log() log.Logger {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.logger == nil {
+		return log.Root()
+	}
+	return s.logger
+}
+
+func (s *Supervisor) targets(index int) []int {
+	var targets []int
+	switch s.conf.Strategy {
+	case OneForAll:
+		for i := range s.children {
+			targets = append(targets, i)
+		}
+	case RestForOne:
+		for i := index; i < len(s.children); i++ {
+			targets = append(targets, i)
+		}
+	default:
+		targets = append(targets, index)
+	}
+	return targets
+}
+
+func (s *Supervisor) shutdown() {
+	for i := len(s.children) - 1; i >= 0; i-- {
+		s.stopChild(i, Finish)
+	}
+}
+
+func (s *Supervisor) startChild(index int) {
+	c := s.children[index]
+	c.gen++
+	c.worker.Start()
+	done, gen := c.worker.Done(), c.gen
+	go func() {
+		select {
+		case <-done:
+			select {
+			case s.exits <- exit{index: index, gen: gen}:
+			case <-s.ctx.Done():
+			}
+		case <-s.ctx.Done():
+		}
+	}()
+}
+
+// stopChild
+// 递增 gen 使旧的退出通知失效, 然后等待 handle 返回
+func (s *Supervisor) stopChild(index int, cause error) {
+	c := s.children[index]
+	c.gen++
+	c.worker.Stop(cause)
+	c.worker.Wait()
+}
+
+func NewSupervisor(conf SupervisorConfig) *Supervisor {
+	conf.MinBackoff = cmp.Or(conf.MinBackoff, 100*time.Millisecond)
+	conf.MaxBackoff = max(cmp.Or(conf.MaxBackoff, 30*time.Second), conf.MinBackoff)
+	conf.MaxRestarts = cmp.Or(conf.MaxRestarts, 3)
+	conf.Window = cmp.Or(conf.Window, 5*time.Second)
+	return &Supervisor{
+		conf:  conf,
+		names: make(map[string]int),
+		exits: make(chan exit),
+		done:  make(chan struct{}),
+	}
+}
@@ -0,0 +1,110 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testSupervisorConfig(strategy Strategy) SupervisorConfig {
+	return SupervisorConfig{
+		Strategy:    strategy,
+		MinBackoff:  time.Millisecond,
+		MaxBackoff:  10 * time.Millisecond,
+		MaxRestarts: 100,
+		Window:      time.Minute,
+	}
+}
+
+func countingHandle(starts *atomic.Int32, crash bool) Handle {
+	return func(ctx context.Context) {
+		if starts.Add(1) == 1 && crash {
+			panic("boom")
+		}
+		<-ctx.Done()
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSupervisorStrategy(t *testing.T) {
+	cases := []struct {
+		strategy Strategy
+		want     [3]int32
+	}{
+		{OneForOne, [3]int32{1, 2, 1}},
+		{OneForAll, [3]int32{2, 2, 2}},
+		{RestForOne, [3]int32{1, 2, 2}},
+	}
+	for _, c := range cases {
+		t.Run(c.strategy.String(), func(t *testing.T) {
+			var starts [3]atomic.Int32
+			s := NewSupervisor(testSupervisorConfig(c.strategy))
+			s.Add("a", countingHandle(&starts[0], false))
+			s.Add("b", countingHandle(&starts[1], true))
+			s.Add("c", countingHandle(&starts[2], false))
+			if err := s.Start(); err != nil {
+				t.Fatal(err)
+			}
+			waitFor(t, func() bool { return starts[1].Load() == 2 })
+			time.Sleep(20 * time.Millisecond)
+			if err := s.Stop(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			for i := range starts {
+				if got := starts[i].Load(); got != c.want[i] {
+					t.Fatalf("worker %d started %d times, want %d", i, got, c.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSupervisorTooManyRestarts(t *testing.T) {
+	conf := testSupervisorConfig(OneForOne)
+	conf.MaxRestarts = 2
+	s := NewSupervisor(conf)
+	s.Add("crash", func(ctx context.Context) {
+		panic("boom")
+	})
+	s.Start()
+	if err := s.Wait(); !errors.Is(err, ErrTooManyRestarts) {
+		t.Fatalf("expected ErrTooManyRestarts, got %v", err)
+	}
+}
+
+func TestSupervisorShutdownOrder(t *testing.T) {
+	var (
+		order = make(chan string, 3)
+		s     = NewSupervisor(testSupervisorConfig(OneForOne))
+	)
+	for _, name := range []string{"a", "b", "c"} {
+		s.Add(name, func(ctx context.Context) {
+			<-ctx.Done()
+			order <- name
+		})
+	}
+	if err := s.Add("a", nil); !errors.Is(err, ErrDuplicateWorker) {
+		t.Fatalf("expected ErrDuplicateWorker, got %v", err)
+	}
+	s.Start()
+	if err := s.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"c", "b", "a"} {
+		if got := <-order; got != want {
+			t.Fatalf("stopped %s, want %s", got, want)
+		}
+	}
+}
@@ -3,9 +3,10 @@ package worker
 import (
 	"context"
 	"errors"
-	"fmt"
-	"runtime"
+	"github.com/yydsqu/tools/log"
+	sync2 "github.com/yydsqu/tools/sync"
 	"sync"
+	"time"
 )
 
 var (
@@ -17,10 +18,22 @@ var (
 type Handle func(ctx context.Context)
 
 type Worker struct {
+	name   string
 	ctx    context.Context
 	cancel context.CancelCauseFunc
+	exited chan struct{}
 	handle Handle
 	mutex  sync.Mutex
+	logger log.Logger
+
+	stateMutex sync.Mutex
+	state      State
+	run        uint64
+	restarts   uint64
+	runningAt  time.Time
+	label      string
+	events     eventBus
+	parent     *eventBus
 }
 
 func (sw *Worker) Start() {
@@ -42,6 +55,7 @@ func (sw *Worker) Stop(err error) {
 	defer sw.mutex.Unlock()
 	if sw.cancel != nil {
 		sw.cancel(err)
+		sw.transition(sw.currentRun(), Stopping, err)
 	}
 }
 
@@ -50,14 +64,65 @@ func (sw *Worker) Restart(wait bool) {
 	defer sw.mutex.Unlock()
 	if sw.cancel != nil {
 		sw.cancel(Restart)
+		sw.transition(sw.currentRun(), Stopping, Restart)
 	}
-	if sw.ctx != nil && wait {
-		<-sw.ctx.Done()
+	if sw.exited != nil && wait {
+		<-sw.exited
 	}
 	sw.ctx, sw.cancel = context.WithCancelCause(context.Background())
 	sw.start()
 }
 
+func (sw *Worker) Name() string {
+	return sw.name
+}
+
+func (sw *Worker) State() State {
+	sw.stateMutex.Lock()
+	defer sw.stateMutex.Unlock()
+	return sw.state
+}
+
+// Uptime
+// 本次进入 Running 以来的时长, 不在运行时为 0
+func (sw *Worker) Uptime() time.Duration {
+	sw.stateMutex.Lock()
+	defer sw.stateMutex.Unlock()
+	if sw.state != Running {
+		return 0
+	}
+	return time.Since(sw.runningAt)
+}
+
+// Restarts
+// 首次启动之后的启动次数
+func (sw *Worker) Restarts() uint64 {
+	sw.stateMutex.Lock()
+	defer sw.stateMutex.Unlock()
+	return sw.restarts
+}
+
+// Subscribe
+// 订阅状态变化事件, 缓冲区满时丢弃事件, 调用返回的函数取消订阅
+func (sw *Worker) Subscribe(size int) (<-chan Event, func()) {
+	return sw.events.subscribe(size)
+}
+
+func (sw *Worker) SetLogger(logger log.Logger) {
+	sw.stateMutex.Lock()
+	defer sw.stateMutex.Unlock()
+	sw.logger = logger
+}
+
+func (sw *Worker) log() log.Logger {
+	sw.stateMutex.Lock()
+	defer sw.stateMutex.Unlock()
+	if sw.logger == nil {
+		return log.Root()
+	}
+	return sw.logger
+}
+
 func (sw *Worker) Status() bool {
 	sw.mutex.Lock()
 	defer sw.mutex.Unlock()
@@ -92,33 +157,158 @@ func (sw *Worker) Err() error {
 	return context.Cause(sw.ctx)
 }
 
+// Wait
+// 等待本次运行的 handle 返回
 func (sw *Worker) Wait() error {
 	sw.mutex.Lock()
-	ctx := sw.ctx
+	ctx, exited := sw.ctx, sw.exited
 	sw.mutex.Unlock()
 	if ctx == nil {
 		return nil
 	}
-	<-ctx.Done()
-	return sw.Err()
+	if exited == nil {
+		<-ctx.Done()
+	} else {
+		<-exited
+	}
+	return context.Cause(ctx)
+}
+
+// Shutdown
+// 以 Finish 停止并等待 handle 返回, ctx 到期后不再等待
+func (sw *Worker) Shutdown(ctx context.Context) error {
+	sw.Stop(Finish)
+	done := make(chan struct{})
+	go func() {
+		sw.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 func (sw *Worker) start() {
 	if sw.handle == nil || sw.ctx == nil || sw.cancel == nil {
+		sw.exited = nil
 		return
 	}
-	go func(ctx context.Context, cancel context.CancelCauseFunc) {
+	sw.exited = make(chan struct{})
+	run := sw.nextRun()
+	go func(ctx context.Context, cancel context.CancelCauseFunc, exited chan struct{}) {
 		defer func() {
 			var err error
 			if r := recover(); r != nil {
-				buf := make([]byte, 1024)
-				n := runtime.Stack(buf, false)
-				err = fmt.Errorf("panic: %v\nstack trace:\n%s", r, buf[:n])
+				err = sw.panicError(r)
 			}
 			cancel(err)
+			if err != nil {
+				sw.transition(run, Crashed, err)
+			} else {
+				sw.transition(run, Stopped, context.Cause(ctx))
+			}
+			if !errors.Is(context.Cause(ctx), Restart) {
+				sw.retire(run)
+			}
+			close(exited)
 		}()
+		sw.transition(run, Running, nil)
 		sw.handle(ctx)
-	}(sw.ctx, sw.cancel)
+	}(sw.ctx, sw.cancel, sw.exited)
+}
+
+// panicError
+// 必须在 recover 所在的 defer 中调用
+func (sw *Worker) panicError(r any) *sync2.PanicError {
+	var seed any
+	if sw.name != "" {
+		seed = sw.name
+	}
+	return sync2.NewPanicError(seed, r)
+}
+
+func (sw *Worker) currentRun() uint64 {
+	sw.stateMutex.Lock()
+	defer sw.stateMutex.Unlock()
+	return sw.run
+}
+
+func (sw *Worker) nextRun() uint64 {
+	sw.stateMutex.Lock()
+	if sw.run > 0 {
+		sw.restarts++
+		if sw.name != "" {
+			restartCounter.WithLabelValues(sw.name).Inc()
+		}
+	}
+	sw.run++
+	run := sw.run
+	if sw.name != "" {
+		sw.label = collector.register(sw)
+	}
+	// 上一次运行的 handle 可能还没返回, 状态停在 Stopping, 新的运行不受 canTransition 限制
+	sw.set(Starting, nil)
+	sw.stateMutex.Unlock()
+	return run
+}
+
+// retire
+// 运行结束且不是 Restart 时从 prometheus 中移除, 再次 Start 时重新注册
+func (sw *Worker) retire(run uint64) {
+	sw.stateMutex.Lock()
+	defer sw.stateMutex.Unlock()
+	if run != sw.run || sw.label == "" {
+		return
+	}
+	collector.unregister(sw.label, sw)
+	sw.label = ""
+}
+
+// transition
+// run 不是当前运行或状态迁移不合法时忽略, 避免旧 goroutine 覆盖新状态
+func (sw *Worker) transition(run uint64, to State, err error) {
+	sw.stateMutex.Lock()
+	defer sw.stateMutex.Unlock()
+	if run != sw.run || !sw.state.canTransition(to) {
+		return
+	}
+	sw.set(to, err)
+}
+
+// set
+// 持有 stateMutex 时调用, 修改状态并发布事件
+func (sw *Worker) set(to State, err error) {
+	event := Event{
+		Worker: sw.name,
+		From:   sw.state,
+		To:     to,
+		Err:    err,
+		Time:   time.Now(),
+	}
+	sw.state = to
+	if to == Running {
+		sw.runningAt = event.Time
+	}
+	sw.events.publish(event)
+	if sw.parent != nil {
+		sw.parent.publish(event)
+	}
+
+	logger := sw.logger
+	if logger == nil {
+		logger = log.Root()
+	}
+	switch to {
+	case Crashed:
+		logger.Error("worker crashed", "worker", sw.name, "err", err)
+	case Stopped:
+		logger.Debug("worker stopped", "worker", sw.name, "from", event.From, "cause", err)
+	default:
+		logger.Trace("worker state changed", "worker", sw.name, "from", event.From, "to", to)
+	}
 }
 
 func NewWorker(handle Handle) *Worker {
@@ -126,3 +316,12 @@ func NewWorker(handle Handle) *Worker {
 		handle: handle,
 	}
 }
+
+// NewNamedWorker
+// 具名 worker 运行期间的运行时长、重启次数和状态会导出到 prometheus, 停止后移除
+func NewNamedWorker(name string, handle Handle) *Worker {
+	return &Worker{
+		name:   name,
+		handle: handle,
+	}
+}
@@ -2,7 +2,10 @@ package worker
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	sync2 "github.com/yydsqu/tools/sync"
+	"strings"
 	"testing"
 	"time"
 )
@@ -26,3 +29,18 @@ func handle(ctx context.Context) {
 
 func TestSingleWorker(t *testing.T) {
 }
+
+func TestWorkerPanic(t *testing.T) {
+	w := NewNamedWorker("panic", func(ctx context.Context) {
+		panic("boom")
+	})
+	w.Start()
+	err := w.Wait()
+	var panicErr *sync2.PanicError
+	if !errors.As(err, &panicErr) {
+		t.Fatalf("expected *PanicError, got %v", err)
+	}
+	if panicErr.Seed != "panic" || !strings.Contains(string(panicErr.Stack), "worker_test.go") {
+		t.Fatalf("unexpected panic error: %#v", panicErr)
+	}
+}